@@ -0,0 +1,339 @@
+package DistributeOrders
+
+/* An Assigner decides which elevator should take which hall request. DistributeOrders uses the one named by the
+dispatch policy in ElevState, which is the same on all elevators and can be switched at runtime (see
+ElevState/DispatchPolicy.go). Every assigner must give the same result on every elevator for the same AllStates,
+so they only depend on the states and break ties by ID. A car only gets hall requests at the floors it serves, and
+the times of each car are used where an assigner counts time (see ElevState/CarParameters.go). Every assigner also
+tells what a call costs each car the way it counts, which is used to explain the assignments (see Explain.go).
+	timeToServe: the time each elevator needs to serve the requests, see HallRequestAssigner (the default)
+	nearestCar:  the elevator closest in time to the request, counting the way it has to turn if it moves away from it
+	energy:      the elevator that uses the least energy, where empty travel and turning around cost extra
+	roundRobin:  the elevators take turns, in the order the requests were made
+	external:    the hall_request_assigner executable, see External.go. It takes every car to be the same
+*/
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"../ElevState"
+	"../HallRequestAssigner"
+)
+
+//An assigner returns the hall requests for every elevator by ID, the same way as HallRequestAssigner.Assign. Costs
+//returns what the hall call costs every car that serves its floor in the assignment it made for the states
+type Assigner interface {
+	Assign(states ElevState.AllStates) (map[string][][2]bool, error)
+	Costs(states ElevState.AllStates, assignment map[string][][2]bool, floor int, button int) []Candidate
+}
+
+//The assigners that can be chosen, by the name used in the dispatch policy
+var assigners = map[string]Assigner{
+	"timeToServe": TimeToServe{},
+	"nearestCar":  NearestCar{},
+	"energy":      Energy{TravelCost: 1, EmptyTravelCost: 2, ReversalCost: 4},
+	"roundRobin":  RoundRobin{},
+	"external":    External{},
+}
+
+const defaultAssigner = "timeToServe" //Used if the policy names an assigner this elevator doesn't know
+
+var unknownAssigners = map[string]bool{} //Names that have been warned about, so the warning is printed once
+
+//Returns the assigner with the given name, and false if there is none
+func LookupAssigner(name string) (Assigner, bool) {
+	assigner, ok := assigners[name]
+	return assigner, ok
+}
+
+//Returns the names of all assigners, sorted
+func AssignerNames() []string {
+	names := []string{}
+	for name := range assigners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Returns the assigner to use for the policy. An unknown name can come from a peer running a newer version,
+//and timeToServe is used instead
+func assignerFor(name string) Assigner {
+	if assigner, ok := assigners[name]; ok {
+		return assigner
+	}
+	if !unknownAssigners[name] {
+		unknownAssigners[name] = true
+		fmt.Println("Unknown assigner", name, "in dispatch policy, using", defaultAssigner)
+	}
+	return assigners[defaultAssigner]
+}
+
+//Assigns by the time each elevator needs to serve the requests
+type TimeToServe struct{}
+
+func (TimeToServe) Assign(states ElevState.AllStates) (map[string][][2]bool, error) {
+	return HallRequestAssigner.Assign(states)
+}
+
+//The cost is the time the car needs to get to the call with its cab requests and the other calls it was given, and
+//the path is the floors it passes in the simulation
+func (TimeToServe) Costs(states ElevState.AllStates, assignment map[string][][2]bool, floor int, button int) []Candidate {
+	candidates := []Candidate{}
+	for id, state := range states.States {
+		others := make([][2]bool, len(states.HallRequests))
+		copy(others, assignment[id])
+		if floor < len(others) {
+			others[floor][button] = false
+		}
+		if duration, path, ok := HallRequestAssigner.TimeToRequest(state, others, floor, button); ok {
+			candidates = append(candidates, Candidate{ID: id, Cost: duration.Seconds(), Unit: "s", Path: path})
+		}
+	}
+	return candidates
+}
+
+//Assigns each request to the elevator with the shortest travel time to it, the floors to travel times its travel
+//time. An elevator moving away from the request is counted as if it first travels to the end of the building
+type NearestCar struct{}
+
+//Returns the travel time of the car to the floor as NearestCar counts it, and the floors it passes
+func nearestCarTravel(state ElevState.SingleStates, floor int, floors int) (time.Duration, []int) {
+	path := pathTo(state, floor, floors)
+	return time.Duration(len(path)) * ElevState.TravelTimeOf(state), path
+}
+
+func (NearestCar) Assign(states ElevState.AllStates) (map[string][][2]bool, error) {
+	ids, assignments, err := prepare(states)
+	if err != nil {
+		return nil, err
+	}
+	floors := len(states.HallRequests)
+	for floor, buttons := range states.HallRequests {
+		for button := 0; button < 2; button++ {
+			if !buttons[button] {
+				continue
+			}
+			best, bestTime := "", time.Duration(0)
+			for _, id := range ids {
+				state := states.States[id]
+				if !ElevState.Serves(state, floor) {
+					continue
+				}
+				travel, _ := nearestCarTravel(state, floor, floors)
+				if best == "" || travel < bestTime {
+					best, bestTime = id, travel
+				}
+			}
+			if best != "" { //No elevator serves the floor
+				assignments[best][floor][button] = true
+			}
+		}
+	}
+	return assignments, nil
+}
+
+func (NearestCar) Costs(states ElevState.AllStates, assignment map[string][][2]bool, floor int, button int) []Candidate {
+	candidates := []Candidate{}
+	for id, state := range states.States {
+		if ElevState.Serves(state, floor) {
+			travel, path := nearestCarTravel(state, floor, len(states.HallRequests))
+			candidates = append(candidates, Candidate{ID: id, Cost: travel.Seconds(), Unit: "s", Path: path})
+		}
+	}
+	return candidates
+}
+
+//Assigns the requests one by one from the bottom floor, each to the elevator it costs the least energy to send
+//there from where its last request left it. Travel without passengers (no cab requests) costs EmptyTravelCost per
+//floor instead of TravelCost, and turning around costs ReversalCost
+type Energy struct {
+	TravelCost      int
+	EmptyTravelCost int
+	ReversalCost    int
+}
+
+func (e Energy) Assign(states ElevState.AllStates) (map[string][][2]bool, error) {
+	assignments, _, err := e.assign(states)
+	return assignments, err
+}
+
+//The cost is the energy it costs to send the car to the call from where its calls before it left it
+func (e Energy) Costs(states ElevState.AllStates, assignment map[string][][2]bool, floor int, button int) []Candidate {
+	_, costs, _ := e.assign(states)
+	return costs[[2]int{floor, button}]
+}
+
+//Returns the assignment and what every call cost the cars when it was assigned
+func (e Energy) assign(states ElevState.AllStates) (map[string][][2]bool, map[[2]int][]Candidate, error) {
+	ids, assignments, err := prepare(states)
+	if err != nil {
+		return nil, nil, err
+	}
+	costs := make(map[[2]int][]Candidate)
+
+	//Where each elevator is and which way it goes after the requests it has been given so far
+	floor := map[string]int{}
+	direction := map[string]int{}
+	loaded := map[string]bool{}
+	for _, id := range ids {
+		state := states.States[id]
+		floor[id] = state.Floor
+		direction[id] = directionOf(state)
+		for _, requested := range state.CabRequests {
+			loaded[id] = loaded[id] || requested
+		}
+	}
+
+	for callFloor, buttons := range states.HallRequests {
+		for button := 0; button < 2; button++ {
+			if !buttons[button] {
+				continue
+			}
+			best, bestCost := "", 0
+			for _, id := range ids {
+				if !ElevState.Serves(states.States[id], callFloor) {
+					continue
+				}
+				travel := abs(callFloor - floor[id])
+				cost := travel * e.TravelCost
+				if !loaded[id] {
+					cost = travel * e.EmptyTravelCost
+				}
+				if travel > 0 && direction[id] != 0 && sign(callFloor-floor[id]) != direction[id] {
+					cost += e.ReversalCost
+				}
+				costs[[2]int{callFloor, button}] = append(costs[[2]int{callFloor, button}], Candidate{ID: id, Cost: float64(cost), Unit: "energy", Path: span(floor[id], callFloor)})
+				if best == "" || cost < bestCost {
+					best, bestCost = id, cost
+				}
+			}
+			if best == "" { //No elevator serves the floor
+				continue
+			}
+			assignments[best][callFloor][button] = true
+			if callFloor != floor[best] {
+				direction[best] = sign(callFloor - floor[best])
+			}
+			floor[best] = callFloor
+			loaded[best] = true //It has the passenger from this request
+		}
+	}
+	return assignments, costs, nil
+}
+
+//Assigns the requests to the elevators in turn, sorted by ID, in the order the requests were made. The order is
+//found from the hall versions, which are a clock shared by all hall buttons (see newHallOrderVersion in ElevState):
+//a request made after another one was seen has a higher version. Requests made at the same time on elevators that
+//hadn't seen each other's are ordered by the elevator that made them. An elevator that doesn't serve the floor of a
+//request passes its turn on to the next one
+type RoundRobin struct{}
+
+func (r RoundRobin) Assign(states ElevState.AllStates) (map[string][][2]bool, error) {
+	assignments, _, err := r.assign(states)
+	return assignments, err
+}
+
+//The cost is the number of turns the car is from the one whose turn it was when the call was assigned
+func (r RoundRobin) Costs(states ElevState.AllStates, assignment map[string][][2]bool, floor int, button int) []Candidate {
+	_, costs, _ := r.assign(states)
+	return costs[[2]int{floor, button}]
+}
+
+//Returns the assignment and how many turns every car was from the one whose turn it was for every call
+func (RoundRobin) assign(states ElevState.AllStates) (map[string][][2]bool, map[[2]int][]Candidate, error) {
+	ids, assignments, err := prepare(states)
+	if err != nil {
+		return nil, nil, err
+	}
+	costs := make(map[[2]int][]Candidate)
+
+	type call struct {
+		floor, button int
+		version       uint64
+	}
+	calls := []call{}
+	for floor, buttons := range states.HallRequests {
+		for button := 0; button < 2; button++ {
+			if buttons[button] {
+				c := call{floor: floor, button: button}
+				if floor < len(states.HallVersions) {
+					c.version = states.HallVersions[floor][button]
+				}
+				calls = append(calls, c)
+			}
+		}
+	}
+	sort.SliceStable(calls, func(i, j int) bool { return calls[i].version < calls[j].version })
+
+	turn := 0
+	for _, c := range calls {
+		assigned := -1
+		for i := 0; i < len(ids); i++ {
+			if id := ids[(turn+i)%len(ids)]; ElevState.Serves(states.States[id], c.floor) {
+				costs[[2]int{c.floor, c.button}] = append(costs[[2]int{c.floor, c.button}], Candidate{ID: id, Cost: float64(i), Unit: "turns"})
+				if assigned < 0 {
+					assignments[id][c.floor][c.button] = true
+					assigned = i
+				}
+			}
+		}
+		turn += assigned + 1
+	}
+	return assignments, costs, nil
+}
+
+//Checks the states and returns the elevator IDs sorted, and an empty assignment for every elevator
+func prepare(states ElevState.AllStates) ([]string, map[string][][2]bool, error) {
+	if len(states.HallRequests) == 0 {
+		return nil, nil, errors.New("no hall requests")
+	}
+	if len(states.States) == 0 {
+		return nil, nil, errors.New("no elevator states")
+	}
+	ids := []string{}
+	assignments := make(map[string][][2]bool)
+	for id, state := range states.States {
+		if state.Floor < 0 || state.Floor >= len(states.HallRequests) {
+			return nil, nil, fmt.Errorf("elevator %s is at floor %d, outside the %d floors", id, state.Floor, len(states.HallRequests))
+		}
+		ids = append(ids, id)
+		assignments[id] = make([][2]bool, len(states.HallRequests))
+	}
+	sort.Strings(ids)
+	return ids, assignments, nil
+}
+
+//Returns 1 for an elevator moving up, -1 for moving down and 0 otherwise
+func directionOf(state ElevState.SingleStates) int {
+	if state.Behavior != "moving" {
+		return 0
+	}
+	switch state.Direction {
+	case "up":
+		return 1
+	case "down":
+		return -1
+	}
+	return 0
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func sign(x int) int {
+	switch {
+	case x > 0:
+		return 1
+	case x < 0:
+		return -1
+	}
+	return 0
+}
@@ -0,0 +1,136 @@
+package DistributeOrders
+
+/* Coalesce makes sure ElevState never has to wait for the assigner. Every AllStates sent on UpdatedAllStates is
+received at once and kept as the latest one, replacing any that was not assigned yet, so the assigner always works
+on the newest states and the ones in between are dropped. If the states that matter for the assignment are the same
+as the last time they were assigned, the assignment is skipped. The number of runs, skips and coalesced states and
+the time from receiving the states to sending the orders to the FSM are kept as metrics.
+*/
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"../ElevState"
+)
+
+//Type of the states waiting to be assigned, with the time they were received
+type pendingStates struct {
+	states   ElevState.AllStates
+	received time.Time
+}
+
+//Type of the metrics of the assignments
+type AssignmentMetrics struct {
+	Received       uint64        //AllStates received from ElevState
+	Coalesced      uint64        //AllStates replaced by newer ones before they were assigned
+	Skipped        uint64        //AllStates not assigned because nothing that matters had changed
+	Runs           uint64        //Times the assigner was run
+	Errors         uint64        //Times the assigner returned an error
+	Held           uint64        //Calls kept with the car committed to them, see Hysteresis.go
+	Diverged       uint64        //Calls given to the designated elevator's choice, see Consistency.go
+	DivergedSame   uint64        //Of those, calls where both had the same states
+	LastLatency    time.Duration //Time from receiving the states to sending the orders, for the last run
+	MaxLatency     time.Duration //The longest latency so far
+	AverageLatency time.Duration //The average latency of all runs
+	LastRun        time.Duration //Time the assigner itself used in the last run
+}
+
+var metrics AssignmentMetrics
+var totalLatency time.Duration
+
+//Makes sure the metrics are not read and written at the same time
+var metricsMtx = sync.Mutex{}
+
+//Returns a copy of the assignment metrics
+func Metrics() AssignmentMetrics {
+	metricsMtx.Lock()
+	defer metricsMtx.Unlock()
+	return metrics
+}
+
+//Receives every AllStates from ElevState and sends only the latest one to the assigner when it is ready for it
+func coalesce(UpdatedAllStates <-chan ElevState.AllStates, latest chan<- pendingStates) {
+	var pending pendingStates
+	waiting := false
+	for {
+		var out chan<- pendingStates //Nil, so nothing is sent, until there are states waiting
+		if waiting {
+			out = latest
+		}
+		select {
+		case states := <-UpdatedAllStates:
+			metricsMtx.Lock()
+			metrics.Received++
+			if waiting {
+				metrics.Coalesced++
+			}
+			metricsMtx.Unlock()
+			pending = pendingStates{states: states, received: time.Now()}
+			waiting = true
+		case out <- pending:
+			waiting = false
+		}
+	}
+}
+
+//Returns a hash of everything the assigners use: the hall requests and versions, the elevator states and the
+//assigner in use. The degraded flags are left out, since an elevator doesn't see its own flag in its states. The
+//states must not share maps with ElevState, see copyStates
+func assignmentHash(states ElevState.AllStates, policy string) [sha256.Size]byte {
+	elevators := make(map[string]ElevState.SingleStates)
+	for id, state := range states.States {
+		state.Degraded = false
+		elevators[id] = state
+	}
+	data, _ := json.Marshal(struct {
+		HallRequests [][2]bool
+		HallVersions [][2]uint64
+		States       map[string]ElevState.SingleStates
+		Policy       string
+	}{states.HallRequests, states.HallVersions, elevators, policy})
+	return sha256.Sum256(data)
+}
+
+func countHeld(held int) {
+	metricsMtx.Lock()
+	defer metricsMtx.Unlock()
+	metrics.Held += uint64(held)
+}
+
+func countDivergence(sameInput bool, calls int) {
+	metricsMtx.Lock()
+	defer metricsMtx.Unlock()
+	metrics.Diverged += uint64(calls)
+	if sameInput {
+		metrics.DivergedSame += uint64(calls)
+	}
+}
+
+func countSkipped() {
+	metricsMtx.Lock()
+	defer metricsMtx.Unlock()
+	metrics.Skipped++
+}
+
+//Counts a run of the assigner, and the latency if the orders were sent to the FSM
+func countRun(received time.Time, run time.Duration, err error) {
+	metricsMtx.Lock()
+	defer metricsMtx.Unlock()
+
+	metrics.Runs++
+	metrics.LastRun = run
+	if err != nil {
+		metrics.Errors++
+		return
+	}
+	latency := time.Since(received)
+	metrics.LastLatency = latency
+	if latency > metrics.MaxLatency {
+		metrics.MaxLatency = latency
+	}
+	totalLatency += latency
+	metrics.AverageLatency = totalLatency / time.Duration(metrics.Runs-metrics.Errors)
+}
@@ -0,0 +1,86 @@
+package DistributeOrders
+
+/* Consistency makes sure the elevators agree on which car takes each hall call. Every elevator runs the assigner on
+its own view of the states, and if the views or the assigners' history differ, two cars can take the same call or
+none takes it. Every elevator sends a digest of its assignment to the others (see ElevState/Consistency.go), and the
+elevator with the lowest ID is designated: if its assignment gives a hall call that both see to another car, the
+designated elevator's choice is used. A divergence where the states were the same is a real inconsistency and is
+logged, one where the states differed is expected for a moment after every change and is only counted.
+*/
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"../ElevState"
+)
+
+//Returns the ID of the designated elevator, the one with the lowest ID. Peers that have not sent a digest for
+//masterTimeout are skipped, so the next one takes over if the designated elevator goes silent
+func designatedPeer(states ElevState.AllStates) string {
+	ids := []string{}
+	for id := range states.States {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if id == ID || ElevState.PeerDigestAge(id) <= masterTimeout {
+			return id
+		}
+	}
+	if len(ids) == 0 {
+		return ""
+	}
+	return ids[0]
+}
+
+//Returns the owner of every hall call, at floor*2+button
+func ownersOf(assignment map[string][][2]bool, floors int) []string {
+	owners := make([]string, 2*floors)
+	for floor := 0; floor < floors; floor++ {
+		for button := 0; button < 2; button++ {
+			owners[2*floor+button] = callOwner(assignment, floor, button)
+		}
+	}
+	return owners
+}
+
+//Returns the digest of the assignment made from states with the given hash
+func digestOf(hash [sha256.Size]byte, assignment map[string][][2]bool, floors int) ElevState.AssignmentDigest {
+	return ElevState.AssignmentDigest{InputHash: hex.EncodeToString(hash[:8]), Owners: ownersOf(assignment, floors)}
+}
+
+//Gives the hall calls that the designated elevator has given to another car to the car it chose, and returns the
+//number of calls moved. Calls only one of them sees, and cars this elevator doesn't know, are left as they are
+func resolveDivergence(assignment map[string][][2]bool, states ElevState.AllStates, local ElevState.AssignmentDigest, designated ElevState.AssignmentDigest, designatedID string) int {
+	moved := 0
+	for floor := range states.HallRequests {
+		for button := 0; button < 2; button++ {
+			i := 2*floor + button
+			if !states.HallRequests[floor][button] || i >= len(designated.Owners) || i >= len(local.Owners) {
+				continue
+			}
+			owner, chosen := local.Owners[i], designated.Owners[i]
+			if chosen == "" || chosen == owner {
+				continue
+			}
+			if _, known := states.States[chosen]; !known {
+				continue
+			}
+			if owner != "" {
+				assignment[owner][floor][button] = false
+			}
+			assignment[chosen][floor][button] = true
+			moved++
+		}
+	}
+	if moved > 0 {
+		countDivergence(local.InputHash == designated.InputHash, moved)
+		if local.InputHash == designated.InputHash {
+			fmt.Println("Assignment diverged from", designatedID, "on the same states, took its choice for", moved, "hall calls")
+		}
+	}
+	return moved
+}
@@ -1,19 +1,19 @@
 package DistributeOrders
 
-/* The DistributeOrders module take in state information of all elevators and uses hall_request_assigner to calculate
-Which elevator should take which order. It access the hall_request_assigner by using terminal commands.
-The information is sent as a JSON version of AllStates (defined in ElevState). Only the relevant information
+/* The DistributeOrders module take in state information of all elevators and uses an Assigner to calculate
+Which elevator should take which order. The assigner is chosen by the dispatch policy in ElevState, see Assigner.go,
+and the default one uses HallRequestAssigner, a Go version of the hall_request_assigner executable. The assigners
+take AllStates (defined in ElevState) as it is. Only the latest states are assigned, see Coalesce.go. Only the relevant information
 for the FSM is sent out of this module. For this implementation only the local elevators orders and state is
 relevant for the FSM
 */
 
 import (
 	"../ElevState"
-	"bytes"
-	"encoding/json"
+	"../driver/elevio"
+	"crypto/sha256"
 	"fmt"
-	"log"
-	"os/exec"
+	"time"
 )
 
 //Makes a struct type that sends the orders and state of the local elevator to the FSM
@@ -24,63 +24,178 @@ type OrderUpdate struct {
 
 var ID string //Peer ID (IP address)
 
-//A function that distribute orders based on the hall_request_assigner.
+var lastAssignment map[string][][2]bool //The last calculated orders, used to find the hall calls that are newly assigned
+var lastHash [sha256.Size]byte          //Hash of the states the last orders were calculated from, see Coalesce.go
+
+//A function that distribute orders based on the Assigner of the dispatch policy.
 //Takes in all elevators states and all hall request and return which elevator should take which order
 //Uses redistribute all orders approach
 func DistributeOrders(CalculatedOrders chan<- OrderUpdate, UpdatedAllStates <-chan ElevState.AllStates) {
+	latest := make(chan pendingStates)
+	go coalesce(UpdatedAllStates, latest)
+
+	//In master mode the states are assigned again now and then, so this elevator takes over if the master goes silent
+	masterCheck := time.NewTicker(masterTimeout / 4)
+	var last pendingStates
+	received := false
+	//A rejoining elevator gets no orders until it has its cab requests back, see ElevState/CabBackup.go
+	recovered := ElevState.CabRecovered()
+
 	for {
 		select {
 
-		case states := <-UpdatedAllStates:  // Gets AllStates input over a channel from the ElevState module
-			ElevState.Mtx.Lock()
+		case pending := <-latest: // Gets the latest AllStates from the ElevState module
+			last, received = pending, true
+			distribute(pending, CalculatedOrders)
 
-			//first we translate our data from struct to JSON
-			JSONStates, err := json.Marshal(states)
-			JSONStatesString := string(JSONStates)
+		case <-recovered: //The states held back while rejoining are assigned, with the recovered cab requests
+			recovered = nil
+			ElevState.Mtx.Lock()
+			states := ElevState.LocalAllStates
 			ElevState.Mtx.Unlock()
-			//Checks for error in converting to JSON
-			if err != nil {
-				fmt.Println("Error in JSON Marshal", err)
-			}
+			distribute(pendingStates{states: states, received: time.Now()}, CalculatedOrders)
 
-			//Uses command to send the JSON to the hall_request_assigner and saves the return
-			cmd := exec.Command("./hall_request_assigner", "-i", JSONStatesString)
+		case <-masterCheck.C:
+			if DispatchMode == DM_Master && received {
+				distribute(last, CalculatedOrders)
+			}
+		}
+	}
+}
 
-			//Makes buffers for the order data and std error check
-			var extractedAssignments bytes.Buffer
-			var stderr bytes.Buffer
+//Assigns the hall requests in the states and sends this elevator's orders to the FSM. ElevState.Mtx is only held
+//while the states are copied, so the assigner, which can run the external executable for
+//more than a second, never keeps ElevState and the Network module waiting
+func distribute(pending pendingStates, CalculatedOrders chan<- OrderUpdate) {
+	if ElevState.AwaitingCabBackups() { //Moving before the cab requests are recovered could pass floors they need
+		return
+	}
+	policy := ElevState.CurrentPolicy().Name
+	ElevState.Mtx.Lock()
+	states := copyStates(pending.states)
+	ElevState.Mtx.Unlock()
+
+	//The assignment of the designated elevator is taken into account, see Consistency.go. In master mode it is the
+	//master, and its assignment is used as it is, see Master.go
+	designatedID := designatedPeer(states)
+	designated, haveDesignated := ElevState.PeerAssignmentDigest(designatedID)
+	haveDesignated = haveDesignated && designatedID != ID && ElevState.PeerDigestAge(designatedID) <= masterTimeout
+	inputHash := assignmentHash(states, policy)
+	hash := inputHash
+	if haveDesignated {
+		hash = sha256.Sum256([]byte(fmt.Sprint(inputHash, designated, DispatchMode)))
+	}
+	if hash == lastHash { //The FSM already has the orders for these states
+		countSkipped()
+		return
+	}
+	start := time.Now()
+	//Only the available elevators take hall requests, the others keep their cab requests
+	available := availableStates(states)
+	orderToUse, degraded, err := map[string][][2]bool{}, false, error(nil)
+	held := 0
+	if DispatchMode == DM_Master && haveDesignated {
+		orderToUse = fromMaster(designated, available)
+		addUnavailable(orderToUse, states)
+		explain(orderToUse, callOwners(orderToUse, available), available, assignerFor(policy), "master "+designatedID, false, designatedID)
+	} else {
+		if len(available.States) > 0 {
+			orderToUse, degraded, err = assignWithFallback(assignerFor(policy), available)
+		}
+		if err == nil { //Calls stay with the cars committed to them, see Hysteresis.go
+			addUnavailable(orderToUse, states)
+			proposed := callOwners(orderToUse, available)
+			orderToUse, held = Stabilize(lastAssignment, orderToUse, available, Hysteresis)
+			if haveDesignated {
+				resolveDivergence(orderToUse, available, digestOf(inputHash, orderToUse, len(states.HallRequests)), designated, designatedID)
+			}
+			costs := assignerFor(policy)
+			if degraded {
+				costs = fallbackAssigner
+			}
+			explain(orderToUse, proposed, available, costs, policy, degraded, designatedID)
+		}
+	}
+	if err == nil {
+		ElevState.SetAssignmentDigest(digestOf(inputHash, orderToUse, len(states.HallRequests)))
+	}
+	ElevState.SetDegraded(degraded)
+	//An error means the states can't be assigned even by the fallback, keep the orders the FSM has until the next update
+	if err != nil {
+		countRun(pending.received, time.Since(start), err)
+		fmt.Println("Error in assigning hall requests:", err)
+		return
+	}
+	run := time.Since(start)
+	countHeld(held)
 
-			//Assign the buffers
-			cmd.Stdout = &extractedAssignments
-			cmd.Stderr = &stderr
+	if _, exists := states.States[ID]; !exists { //The FSM needs this elevator's state along with its orders
+		fmt.Println("Error in assigning hall requests: no state for this elevator")
+		return
+	}
+	publishAssignments(lastAssignment, orderToUse)
+	lastAssignment = orderToUse
+	lastHash = hash
+
+	//Extract the orders and state for the local elevator, since the FSM only need the local elevator information
+	res := OrderUpdate{
+		DistributedOrders: orderToUse[ID],
+		State:             states.States[ID],
+	}
 
-			//Runs the command and checks for errors
-			e := cmd.Run()
-			if e != nil {
-				log.Panic(fmt.Sprint(e) + ": " + stderr.String())
-			}
+	//Sends the OrderUpdate struct to FSM over channel
+	CalculatedOrders <- res
+	countRun(pending.received, run, nil)
+}
 
-			//Make a map for all the orders and translate from JSON to this map
-			orderMap := new(map[string][][2]bool)
+//Returns a copy of the states that shares no maps or slices with ElevState. Must be called with ElevState.Mtx locked
+func copyStates(original ElevState.AllStates) ElevState.AllStates {
+	states := ElevState.AllStates{
+		HallRequests: append([][2]bool(nil), original.HallRequests...),
+		HallOrders:   append([][2]ElevState.HallOrderState(nil), original.HallOrders...),
+		HallVersions: append([][2]uint64(nil), original.HallVersions...),
+		States:       make(map[string]ElevState.SingleStates),
+	}
+	for id, state := range original.States {
+		state.CabRequests = append([]bool(nil), state.CabRequests...)
+		if state.ServedFloors != nil {
+			state.ServedFloors = append([]bool(nil), state.ServedFloors...)
+		}
+		states.States[id] = state
+	}
+	return states
+}
 
-			err = json.Unmarshal(extractedAssignments.Bytes(), orderMap)
-			if err != nil {
-				fmt.Println("Error in JSON UnMarshal", err)
-			}
+//Returns the states with only the elevators that can take hall requests
+func availableStates(states ElevState.AllStates) ElevState.AllStates {
+	available := states
+	available.States = make(map[string]ElevState.SingleStates)
+	for id, state := range states.States {
+		if ElevState.IsAvailable(state) {
+			available.States[id] = state
+		}
+	}
+	return available
+}
 
-			ElevState.Mtx.Lock()
-			//Transfer what the Unmarshal information point to, to a new variable to avoid pointer type problem
-			orderToUse := *orderMap
+//Gives the unavailable elevators an assignment without hall requests
+func addUnavailable(assignment map[string][][2]bool, states ElevState.AllStates) {
+	for id := range states.States {
+		if _, exists := assignment[id]; !exists {
+			assignment[id] = make([][2]bool, len(states.HallRequests))
+		}
+	}
+}
 
-			//Extract the orders and state for the local elevator, since the FSM only need the local elevator information
-			res := OrderUpdate{
-				DistributedOrders: orderToUse[ID],
-				State:             states.States[ID],
+//Publishes a CallAssigned event on the ElevState event bus for every hall call that is assigned to a new car
+func publishAssignments(previous map[string][][2]bool, current map[string][][2]bool) {
+	for id, orders := range current {
+		for floor := range orders {
+			for button := 0; button < 2; button++ {
+				if orders[floor][button] && !(floor < len(previous[id]) && previous[id][floor][button]) {
+					ElevState.Publish(ElevState.Event{Type: ElevState.EV_CallAssigned, PeerID: id, Floor: floor, Button: elevio.ButtonType(button)})
+				}
 			}
-			ElevState.Mtx.Unlock()
-
-			//Sends the OrderUpdate struct to FSM over channel
-			CalculatedOrders <- res
 		}
 	}
 }
@@ -0,0 +1,122 @@
+package DistributeOrders
+
+/* Explain records why every hall call went to the car it did. After every assignment an explanation is made for
+each active hall call: the assigner that was used, the car that won and why, and for every candidate car what the
+call costs it and the floors it passes on the way, the way the assigner counts them (see Costs in Assigner.go).
+timeToServe gives the time in its simulation, with the other calls the car was given, nearestCar the travel time,
+energy the energy and roundRobin how many turns the car was from getting the call. The latest explanation of every
+call can be looked up with Explain, and with -EXPLAIN they are logged as JSON lines whenever a call gets a new car.
+*/
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"../ElevState"
+	"../driver/elevio"
+)
+
+var LogExplanations bool //Log an explanation every time a call gets a new car
+
+//Type of a car that could have taken a hall call
+type Candidate struct {
+	ID   string  `json:"id"`
+	Cost float64 `json:"cost"`           //What the call costs the car as the assigner counts it, the lowest is the best
+	Unit string  `json:"unit"`           //"s" for time, "energy" or "turns"
+	Path []int   `json:"path,omitempty"` //The floors the car passes on the way, as the assigner counts them
+}
+
+//Type of the explanation of why a hall call went to a car
+type Explanation struct {
+	Time       time.Time         `json:"time"`
+	Floor      int               `json:"floor"`
+	Button     elevio.ButtonType `json:"button"`
+	Assigner   string            `json:"assigner"`
+	Winner     string            `json:"winner"`
+	Reason     string            `json:"reason"`
+	Candidates []Candidate       `json:"candidates"` //Sorted by cost, the lowest first
+}
+
+var explanations = make(map[[2]int]Explanation) //The latest explanation of every hall call, by floor and button
+
+//Makes sure the explanations are not read and written at the same time
+var explainMtx = sync.Mutex{}
+
+//Returns the latest explanation of the hall call, and false if the call has not been assigned
+func Explain(floor int, button elevio.ButtonType) (Explanation, bool) {
+	explainMtx.Lock()
+	defer explainMtx.Unlock()
+	explanation, exists := explanations[[2]int{floor, int(button)}]
+	return explanation, exists
+}
+
+//Returns the car that has the hall call in the assignment, or an empty string if none has
+func callOwner(assignment map[string][][2]bool, floor int, button int) string {
+	for id, orders := range assignment {
+		if floor < len(orders) && orders[floor][button] {
+			return id
+		}
+	}
+	return ""
+}
+
+//Returns the owner of every active hall call, by floor and button
+func callOwners(assignment map[string][][2]bool, states ElevState.AllStates) map[[2]int]string {
+	owners := make(map[[2]int]string)
+	for floor := range states.HallRequests {
+		for button := 0; button < 2; button++ {
+			if states.HallRequests[floor][button] {
+				owners[[2]int{floor, button}] = callOwner(assignment, floor, button)
+			}
+		}
+	}
+	return owners
+}
+
+//Makes the explanations for the assignment. proposed holds the owners the assigner chose, before hysteresis and the
+//designated elevator's choices, and costs is the assigner that made it. The states must not share maps with
+//ElevState, see copyStates
+func explain(assignment map[string][][2]bool, proposed map[[2]int]string, states ElevState.AllStates, costs Assigner, assigner string, degraded bool, designatedID string) {
+	designated, _ := ElevState.PeerAssignmentDigest(designatedID)
+	now := time.Now()
+	current := make(map[[2]int]Explanation)
+	for call, assignedBy := range proposed {
+		explanation := Explanation{Time: now, Floor: call[0], Button: elevio.ButtonType(call[1]), Assigner: assigner}
+		explanation.Winner = callOwner(assignment, call[0], call[1])
+		switch i := 2*call[0] + call[1]; {
+		case explanation.Winner != assignedBy && designatedID != ID && i < len(designated.Owners) && designated.Owners[i] == explanation.Winner:
+			explanation.Reason = "chosen by the designated elevator " + designatedID + " (consistency)"
+		case explanation.Winner != assignedBy:
+			explanation.Reason = "kept by the car on its way there (hysteresis)"
+		case degraded:
+			explanation.Reason = "chosen by the fallback assigner, " + assigner + " failed"
+		default:
+			explanation.Reason = "chosen by " + assigner
+		}
+		explanation.Candidates = costs.Costs(states, assignment, call[0], call[1])
+		sort.Slice(explanation.Candidates, func(i, j int) bool {
+			a, b := explanation.Candidates[i], explanation.Candidates[j]
+			return a.Cost < b.Cost || (a.Cost == b.Cost && a.ID < b.ID)
+		})
+		current[call] = explanation
+	}
+
+	explainMtx.Lock()
+	previous := explanations
+	explanations = current
+	explainMtx.Unlock()
+
+	if !LogExplanations {
+		return
+	}
+	for call, explanation := range current {
+		if old, exists := previous[call]; exists && old.Winner == explanation.Winner {
+			continue
+		}
+		data, _ := json.Marshal(explanation)
+		log.Println(string(data))
+	}
+}
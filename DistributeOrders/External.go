@@ -0,0 +1,95 @@
+package DistributeOrders
+
+/* The external assigner runs the hall_request_assigner executable, the way DistributeOrders did before the Go port,
+for installations that want to use their own build of it. Every run has a timeout and is retried a few times, and
+the output must have the right number of floors for every elevator, otherwise an error is returned and
+DistributeOrders falls back to another assigner, see Fallback.go.
+*/
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"../ElevState"
+)
+
+var ExternalPath = "./hall_request_assigner" //The executable used by the external assigner
+
+const externalTimeout = 500 * time.Millisecond //How long one run of the executable may take
+const externalRetries = 2                      //How many times a failed run is tried again
+
+//Assigns with the hall_request_assigner executable
+type External struct{}
+
+func (External) Assign(states ElevState.AllStates) (map[string][][2]bool, error) {
+	input, err := json.Marshal(states)
+	if err != nil {
+		return nil, err
+	}
+
+	var assignments map[string][][2]bool
+	for attempt := 0; ; attempt++ {
+		assignments, err = runExternal(input)
+		if err == nil {
+			err = checkAssignment(assignments, states)
+		}
+		if err == nil || attempt == externalRetries {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s failed %d times, last error: %v", ExternalPath, externalRetries+1, err)
+	}
+	return assignments, nil
+}
+
+//The executable doesn't tell its costs, but it simulates the cars the same way as timeToServe, with the default
+//times for every car and every floor served
+func (External) Costs(states ElevState.AllStates, assignment map[string][][2]bool, floor int, button int) []Candidate {
+	same := ElevState.AllStates{HallRequests: states.HallRequests, States: make(map[string]ElevState.SingleStates)}
+	for id, state := range states.States {
+		state.TravelTime, state.DoorTime, state.ServedFloors = 0, 0, nil
+		same.States[id] = state
+	}
+	return TimeToServe{}.Costs(same, assignment, floor, button)
+}
+
+//Runs the executable once, and kills it if it takes longer than the timeout
+func runExternal(input []byte) (map[string][][2]bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), externalTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ExternalPath, "-i", string(input))
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("timed out after %v", externalTimeout)
+		}
+		return nil, fmt.Errorf("%v: %s", err, stderr.String())
+	}
+	assignments := make(map[string][][2]bool)
+	if err := json.Unmarshal(stdout.Bytes(), &assignments); err != nil {
+		return nil, fmt.Errorf("malformed output: %v", err)
+	}
+	return assignments, nil
+}
+
+//Returns an error if the assignment doesn't have the hall requests of every floor for every elevator
+func checkAssignment(assignments map[string][][2]bool, states ElevState.AllStates) error {
+	for id := range states.States {
+		orders, exists := assignments[id]
+		if !exists {
+			return fmt.Errorf("no hall requests for elevator %s", id)
+		}
+		if len(orders) != len(states.HallRequests) {
+			return fmt.Errorf("%d floors of hall requests for elevator %s, expected %d", len(orders), id, len(states.HallRequests))
+		}
+	}
+	return nil
+}
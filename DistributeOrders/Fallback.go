@@ -0,0 +1,63 @@
+package DistributeOrders
+
+/* Fallback keeps the elevators serving hall requests when the assigner fails. If the assigner in use returns an
+error or an assignment without the hall requests of every floor for every elevator, nearestCar is used instead,
+and if that fails too, this elevator takes all the hall requests itself. While the fallback is used the elevator is
+marked as degraded in its state (see ElevState/Degraded.go), until the assigner works again.
+*/
+
+import (
+	"errors"
+	"fmt"
+
+	"../ElevState"
+)
+
+var fallbackAssigner Assigner = NearestCar{} //Used if the assigner in use fails
+
+//Assigns with the assigner, or with the fallback if it fails. Returns true if the fallback was used
+func assignWithFallback(assigner Assigner, states ElevState.AllStates) (map[string][][2]bool, bool, error) {
+	assignments, err := assigner.Assign(states)
+	if err == nil {
+		err = checkAssignment(assignments, states)
+	}
+	if err == nil {
+		return assignments, false, nil
+	}
+	fmt.Println("Error in assigning hall requests:", err)
+
+	assignments, err = fallbackAssigner.Assign(states)
+	if err == nil {
+		err = checkAssignment(assignments, states)
+	}
+	if err == nil {
+		return assignments, true, nil
+	}
+	fmt.Println("Error in fallback assigner:", err)
+
+	assignments, err = serveLocally(states)
+	return assignments, true, err
+}
+
+//Gives all the hall requests at the floors this elevator serves to it, and none to the others. If this elevator is
+//not in the states because it is unavailable, the one with the lowest ID takes them
+func serveLocally(states ElevState.AllStates) (map[string][][2]bool, error) {
+	if len(states.HallRequests) == 0 {
+		return nil, errors.New("no hall requests")
+	}
+	assignments := make(map[string][][2]bool)
+	for id := range states.States {
+		assignments[id] = make([][2]bool, len(states.HallRequests))
+	}
+	taker := ID
+	if _, exists := states.States[ID]; !exists {
+		taker = designatedPeer(states)
+	}
+	assignments[taker] = make([][2]bool, len(states.HallRequests))
+	for floor := range states.HallRequests {
+		if ElevState.Serves(states.States[taker], floor) {
+			assignments[taker][floor] = states.HallRequests[floor]
+		}
+	}
+	return assignments, nil
+}
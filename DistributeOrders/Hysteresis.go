@@ -0,0 +1,98 @@
+package DistributeOrders
+
+/* Hysteresis stops hall calls from bouncing between cars. Every elevator runs the assigner on its own, slightly
+different and slightly old view of the states, so two cars that are about as far from a call can take turns getting
+it, and both start and turn around. Once a car is committed to a call, it keeps the call unless another car can
+get there more than the hysteresis threshold (-HYSTERESIS) sooner, or the committed car is gone from the states
+because of a fault. A car is committed when it was given the call last time and is moving towards it or has its
+door open at the floor of the call. Every elevator keeps the call with the car it gave it to last, so elevators with
+different histories can disagree, until the ones that are not designated take the designated one's choice, see
+Consistency.go. Hysteresis_test.go shows calls flip without it and stay with it.
+*/
+
+import (
+	"time"
+
+	"../ElevState"
+)
+
+var Hysteresis = 3 * time.Second //How much sooner another car must get to a committed call to take it, 0 turns it off
+
+//Returns the assignment to use, where the calls in proposed that have moved away from a car committed to them in
+//previous are given back to it, unless the new car gets there more than threshold sooner. Also returns the number
+//of calls given back
+func Stabilize(previous map[string][][2]bool, proposed map[string][][2]bool, states ElevState.AllStates, threshold time.Duration) (map[string][][2]bool, int) {
+	if threshold <= 0 || previous == nil {
+		return proposed, 0
+	}
+
+	held := 0
+	for floor := range states.HallRequests {
+		for button := 0; button < 2; button++ {
+			if !states.HallRequests[floor][button] {
+				continue
+			}
+			committed, assigned := callOwner(previous, floor, button), callOwner(proposed, floor, button)
+			if committed == "" || assigned == "" || committed == assigned {
+				continue
+			}
+			committedState, present := states.States[committed]
+			if !present || !isCommitted(committedState, floor) || len(proposed[committed]) <= floor {
+				continue //The car is gone or has turned away, the call goes to the new car
+			}
+			floors := len(states.HallRequests)
+			if timeToReach(committedState, floor, floors)-timeToReach(states.States[assigned], floor, floors) > threshold {
+				continue //The new car is so much sooner that it is worth moving the call
+			}
+			proposed[assigned][floor][button] = false
+			proposed[committed][floor][button] = true
+			held++
+		}
+	}
+	return proposed, held
+}
+
+//Returns true if the car is on its way to the floor: moving towards it, or standing at it with the door open
+func isCommitted(state ElevState.SingleStates, floor int) bool {
+	if state.Floor == floor {
+		return state.Behavior == "doorOpen" || state.Behavior == "moving"
+	}
+	return directionOf(state) != 0 && directionOf(state) == sign(floor-state.Floor)
+}
+
+//Estimates the time a car needs to get to the floor with its own travel and door times. A car moving away from the
+//floor first goes to the end of the building, and the car stops at every cab request on the way
+func timeToReach(state ElevState.SingleStates, floor int, floors int) time.Duration {
+	path := pathTo(state, floor, floors)
+	duration := time.Duration(len(path)) * ElevState.TravelTimeOf(state)
+	for _, f := range path {
+		if f != floor && f < len(state.CabRequests) && state.CabRequests[f] {
+			duration += ElevState.DoorTimeOf(state)
+		}
+	}
+	if state.Behavior == "doorOpen" {
+		duration += ElevState.DoorTimeOf(state) / 2
+	}
+	return duration
+}
+
+//Returns the floors a car passes on its way to the floor, without the one it is at
+func pathTo(state ElevState.SingleStates, floor int, floors int) []int {
+	switch direction := directionOf(state); {
+	case direction == 1 && floor < state.Floor:
+		return append(span(state.Floor, floors-1), span(floors-1, floor)...)
+	case direction == -1 && floor > state.Floor:
+		return append(span(state.Floor, 0), span(0, floor)...)
+	}
+	return span(state.Floor, floor)
+}
+
+//Returns the floors passed going from one floor to another, without the first one
+func span(from int, to int) []int {
+	floors := []int{}
+	for f := from; f != to; {
+		f += sign(to - from)
+		floors = append(floors, f)
+	}
+	return floors
+}
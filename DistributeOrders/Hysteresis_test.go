@@ -0,0 +1,151 @@
+package DistributeOrders
+
+import (
+	"crypto/sha256"
+	"math/rand"
+	"testing"
+	"time"
+
+	"../ElevState"
+)
+
+const hysteresisFloors = 8
+const callFloor = 4
+
+//Two cars move towards the same call from each side, and every car sees both a floor further away at random from
+//update to update. Without hysteresis the call bounces between them, with it the call never changes car
+func TestHysteresisStopsFlips(t *testing.T) {
+	for _, name := range AssignerNames() {
+		assigner, _ := LookupAssigner(name)
+		if _, err := assigner.Assign(jitteredStates(rand.New(rand.NewSource(1)))); err != nil {
+			t.Logf("%s skipped: %v", name, err) //The external assigner needs the executable
+			continue
+		}
+		without := flips(t, assigner, rand.New(rand.NewSource(1)), 0)
+		with := flips(t, assigner, rand.New(rand.NewSource(1)), Hysteresis)
+		t.Logf("%s: %d flips without hysteresis, %d with", name, without, with)
+		if with > 0 {
+			t.Errorf("%s: the call changed car %d times with hysteresis", name, with)
+		}
+	}
+}
+
+//Every car stabilizes against its own last assignment, so two cars with different histories can each keep the call
+//with the car they gave it to. The car that is not designated takes the designated car's choice (see
+//Consistency.go), and since the designated car keeps the call where it is, the two agree from the next update on
+func TestHysteresisConverges(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	assigner, _ := LookupAssigner("timeToServe")
+	ids := []string{"car-a", "car-b"} //car-a is designated
+	previous := map[string]map[string][][2]bool{"car-a": callWith("car-a"), "car-b": callWith("car-b")}
+	var designated ElevState.AssignmentDigest
+
+	for update := 0; update < 200; update++ {
+		for _, id := range ids {
+			states := jitteredStates(random) //Every car has its own view
+			assignment, err := assigner.Assign(states)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assignment, _ = Stabilize(previous[id], assignment, states, Hysteresis)
+			if id != ids[0] && update > 0 {
+				resolveDivergence(assignment, states, digestOf(sha256.Sum256([]byte(id)), assignment, hysteresisFloors), designated, ids[0])
+			}
+			previous[id] = assignment
+		}
+		//The digest of the designated car reaches the other one with the next update
+		designated = digestOf(sha256.Sum256([]byte(ids[0])), previous[ids[0]], hysteresisFloors)
+
+		owners := []string{callOwner(previous["car-a"], callFloor, 1), callOwner(previous["car-b"], callFloor, 1)}
+		if update > 0 && owners[0] != owners[1] {
+			t.Fatalf("update %d: car-a gives the call to %s and car-b to %s", update, owners[0], owners[1])
+		}
+	}
+}
+
+//The committed car keeps the call when the new car gets there exactly the threshold sooner, and loses it when the
+//new car is any sooner than that. A car that is gone or has turned away always loses it
+func TestHysteresisThreshold(t *testing.T) {
+	travel := 2 * time.Second
+	states := newHysteresisStates()
+	states.States["car-a"] = ElevState.SingleStates{Behavior: "moving", Floor: callFloor - 4, Direction: "up", CabRequests: make([]bool, hysteresisFloors), TravelTime: travel}
+	states.States["car-b"] = ElevState.SingleStates{Behavior: "idle", Floor: callFloor - 1, Direction: "stop", CabRequests: make([]bool, hysteresisFloors), TravelTime: travel}
+	gain := 3 * travel //car-a is 4 floors away and car-b 1
+
+	turned := copyStates(states)
+	turned.States["car-a"] = ElevState.SingleStates{Behavior: "moving", Floor: callFloor - 4, Direction: "down", CabRequests: make([]bool, hysteresisFloors), TravelTime: travel}
+	gone := copyStates(states)
+	delete(gone.States, "car-a")
+
+	for _, test := range []struct {
+		name      string
+		states    ElevState.AllStates
+		threshold time.Duration
+		want      string
+		held      int
+	}{
+		{"gain at the threshold", states, gain, "car-a", 1},
+		{"gain just above the threshold", states, gain - time.Nanosecond, "car-b", 0},
+		{"hysteresis off", states, 0, "car-b", 0},
+		{"committed car turned away", turned, gain, "car-b", 0},
+		{"committed car gone", gone, gain, "car-b", 0},
+	} {
+		assignment, held := Stabilize(callWith("car-a"), callWith("car-b"), test.states, test.threshold)
+		if owner := callOwner(assignment, callFloor, 1); owner != test.want {
+			t.Errorf("%s: the call went to %s, want %s", test.name, owner, test.want)
+		}
+		if held != test.held {
+			t.Errorf("%s: %d calls held, want %d", test.name, held, test.held)
+		}
+	}
+}
+
+//Runs the assigner on jittered states of two cars moving towards the call, and returns the number of times the call
+//changed car
+func flips(t *testing.T, assigner Assigner, random *rand.Rand, threshold time.Duration) int {
+	var previous map[string][][2]bool
+	owner, changes := "", 0
+	for i := 0; i < 1000; i++ {
+		states := jitteredStates(random)
+		assignment, err := assigner.Assign(states)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assignment, _ = Stabilize(previous, assignment, states, threshold)
+		previous = assignment
+
+		if id := callOwner(assignment, callFloor, 1); id != "" {
+			if owner != "" && owner != id {
+				changes++
+			}
+			owner = id
+		}
+	}
+	return changes
+}
+
+//Returns the states of two cars moving towards the call, each reported a floor further away at random
+func jitteredStates(random *rand.Rand) ElevState.AllStates {
+	states := newHysteresisStates()
+	states.States["car-a"] = ElevState.SingleStates{Behavior: "moving", Floor: callFloor - 2 - random.Intn(2), Direction: "up", CabRequests: make([]bool, hysteresisFloors)}
+	states.States["car-b"] = ElevState.SingleStates{Behavior: "moving", Floor: callFloor + 2 + random.Intn(2), Direction: "down", CabRequests: make([]bool, hysteresisFloors)}
+	return states
+}
+
+//Returns the states with the call and no cars
+func newHysteresisStates() ElevState.AllStates {
+	states := ElevState.AllStates{
+		HallRequests: make([][2]bool, hysteresisFloors),
+		HallVersions: make([][2]uint64, hysteresisFloors),
+		States:       make(map[string]ElevState.SingleStates),
+	}
+	states.HallRequests[callFloor][1] = true
+	return states
+}
+
+//Returns an assignment of both cars where the call goes to the given one
+func callWith(id string) map[string][][2]bool {
+	assignment := map[string][][2]bool{"car-a": make([][2]bool, hysteresisFloors), "car-b": make([][2]bool, hysteresisFloors)}
+	assignment[id][callFloor][1] = true
+	return assignment
+}
@@ -0,0 +1,45 @@
+package DistributeOrders
+
+/* Master is the optional master-based dispatch mode, chosen with -DISPATCH=master, which must be the same on all
+elevators. The elevator with the lowest ID is the master: only it runs the assigner, and its assignment is sent to
+the others in its assignment digest (see Consistency.go), which they use as it is. If no digest has been received
+from the master for masterTimeout, or the master is lost from the network, the elevator with the next lowest ID
+runs the assigner instead, so a new master takes over within a second of the old one going silent.
+*/
+
+import (
+	"time"
+
+	"../ElevState"
+)
+
+//The dispatch modes
+const (
+	DM_Distributed = "distributed" //Every elevator runs the assigner
+	DM_Master      = "master"      //Only the master runs the assigner
+)
+
+var DispatchMode = DM_Distributed
+
+const masterTimeout = time.Second //How long the master can be silent before another elevator takes over
+
+//Returns the assignment from the master's digest. Calls only the master sees and cars only the master knows are
+//left out, and calls the master has not assigned yet are left without a car until it has
+func fromMaster(master ElevState.AssignmentDigest, states ElevState.AllStates) map[string][][2]bool {
+	assignment := make(map[string][][2]bool)
+	for id := range states.States {
+		assignment[id] = make([][2]bool, len(states.HallRequests))
+	}
+	for floor := range states.HallRequests {
+		for button := 0; button < 2; button++ {
+			i := 2*floor + button
+			if !states.HallRequests[floor][button] || i >= len(master.Owners) {
+				continue
+			}
+			if orders, known := assignment[master.Owners[i]]; known {
+				orders[floor][button] = true
+			}
+		}
+	}
+	return assignment
+}
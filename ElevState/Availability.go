@@ -0,0 +1,45 @@
+package ElevState
+
+/* Availability tells the other elevators whether an elevator can take hall requests. It is sent in the elevator's
+state, and DistributeOrders leaves unavailable elevators out when it assigns hall requests, on every elevator
+including the unavailable one, while its cab requests stay with it. An elevator is unavailable while its motor
+doesn't work, while its door is obstructed for too long, and while it is in maintenance. The faults are reported by
+the FSM and cleared when it works again, maintenance is switched from the terminal and kept after a restart.
+*/
+
+//The availability of an elevator
+const (
+	AV_Available   = "available"
+	AV_MotorFault  = "motorFault"
+	AV_DoorFault   = "doorFault"
+	AV_Maintenance = "maintenance"
+)
+
+//The faults of this elevator. Only changed by UpdateFromFSM and InitElevState
+var motorFault, doorFault, maintenance bool
+
+//Returns true if the elevator can take hall requests. Elevators that don't send their availability are available
+func IsAvailable(state SingleStates) bool {
+	return state.Availability == "" || state.Availability == AV_Available
+}
+
+//Returns the availability of this elevator. A motor fault is the most serious, then a door fault, then maintenance
+func localAvailability() string {
+	switch {
+	case motorFault:
+		return AV_MotorFault
+	case doorFault:
+		return AV_DoorFault
+	case maintenance:
+		return AV_Maintenance
+	}
+	return AV_Available
+}
+
+//Sets this elevator's availability in states
+func applyAvailability(states AllStates) AllStates {
+	tmp := states.States[ID]
+	tmp.Availability = localAvailability()
+	states.States[ID] = tmp
+	return states
+}
@@ -0,0 +1,223 @@
+package ElevState
+
+/* Backup handles the crash-safe storage of LocalAllStates on disk. Every snapshot is written to a temporary file,
+synced to disk and then renamed over the old one, so a crash in the middle of a write never leaves a half written
+backup behind, and there is always a snapshot at the backup path. The previous snapshot is kept as a ".bak" file,
+and every snapshot carries a schema version and a checksum, so that a corrupt or unknown file is skipped and the
+newest good snapshot is used instead.
+*/
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const backupVersion = 1                        //Version of the snapshot schema written by this program
+const legacyBackupPath = "elevator_states.txt" //Unversioned backup file used by earlier versions of the program
+
+var BackupPath string //Path of the backup file, if empty it is elevator_states_<ID>.txt in the working directory
+
+//Makes sure only one snapshot is written at the time, since the temporary file is shared
+var backupMtx = sync.Mutex{}
+
+//Type that contains everything that is saved in a snapshot
+type snapshotPayload struct {
+	AllStates  AllStates         `json:"allStates"`
+	JournalSeq uint64            `json:"journalSeq"` //Sequence number of the last journal entry included in the snapshot
+	CabBackups map[string][]bool `json:"cabBackups"` //Copies of the other elevators' cab requests
+	Policy     DispatchPolicy    `json:"policy"`     //The dispatch policy, see DispatchPolicy.go
+}
+
+//Type that is written to the backup file, the checksum is calculated over the raw payload
+type snapshotFile struct {
+	Version  int             `json:"version"`
+	Checksum string          `json:"checksum"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+//Returns the path of the backup file for this elevator
+func backupPath() string {
+	if BackupPath != "" {
+		return BackupPath
+	}
+	//Replace all characters that are not safe in a file name
+	safeID := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, ID)
+	return fmt.Sprintf("elevator_states_%s.txt", safeID)
+}
+
+// function that saves the states and hall requests of the elevator
+func savingFile(states AllStates, ID string) {
+	err := writeSnapshot(backupPath(), snapshotPayload{AllStates: states, CabBackups: CabBackupsCopy(), Policy: CurrentPolicy()})
+	if err != nil { //A failed backup should not stop the elevator, the next event will try again
+		fmt.Println("Error saving states to file:", err)
+	}
+}
+
+//Writes a snapshot to a temporary file, syncs it to disk and renames it over the old snapshot
+func writeSnapshot(path string, payload snapshotPayload) error {
+	backupMtx.Lock()
+	defer backupMtx.Unlock()
+
+	rawPayload, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(rawPayload)
+	data, err := json.Marshal(snapshotFile{Version: backupVersion, Checksum: hex.EncodeToString(sum[:]), Payload: rawPayload})
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync() //Make sure the data is on disk before it replaces the old snapshot
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	//Keep the old snapshot as the last good one, then rename the new one over it. The rename replaces the old
+	//snapshot in one step, so the backup path is never empty
+	if _, err := os.Stat(path); err == nil {
+		if err := keepPrevious(path, path+".bak"); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+//Makes backup a hard link to the snapshot at path, or a copy of it where hard links are not supported
+func keepPrevious(path string, backup string) error {
+	if err := os.Remove(backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if os.Link(path, backup) == nil {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(backup, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+//Syncs a directory so that renames in it survive a power loss. Not supported on every platform, so errors are ignored
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+//Reads and verifies a snapshot. Files from before the versioned format are accepted as they are
+func loadSnapshot(path string) (snapshotPayload, error) {
+	payload := snapshotPayload{}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return payload, err
+	}
+
+	file := snapshotFile{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return payload, err
+	}
+
+	switch {
+	case file.Version == 0 && file.Payload == nil: //Unversioned file, contains AllStates directly
+		if err := json.Unmarshal(data, &payload.AllStates); err != nil {
+			return payload, err
+		}
+	case file.Version == backupVersion:
+		sum := sha256.Sum256(file.Payload)
+		if hex.EncodeToString(sum[:]) != file.Checksum {
+			return payload, errors.New("checksum mismatch")
+		}
+		if err := json.Unmarshal(file.Payload, &payload); err != nil {
+			return payload, err
+		}
+	default:
+		return payload, fmt.Errorf("unknown snapshot version %d", file.Version)
+	}
+
+	if payload.AllStates.HallOrders == nil { //Backups from before the hall orders existed have the confirmed ones as requests
+		payload.AllStates.HallOrders = hallOrdersFromRequests(payload.AllStates.HallRequests)
+	}
+	if payload.AllStates.HallVersions == nil {
+		payload.AllStates.HallVersions = make([][2]uint64, len(payload.AllStates.HallOrders))
+	}
+	return payload, validateAllStates(payload.AllStates)
+}
+
+//Checks that AllStates has the right dimensions, so that it can't make the program index out of range
+func validateAllStates(states AllStates) error {
+	if len(states.HallRequests) != NFLOORS || len(states.HallOrders) != NFLOORS || len(states.HallVersions) != NFLOORS {
+		return fmt.Errorf("expected %d floors of hall requests and orders, got %d, %d and %d", NFLOORS, len(states.HallRequests), len(states.HallOrders), len(states.HallVersions))
+	}
+	if states.States == nil {
+		return errors.New("no elevator states")
+	}
+	for id, state := range states.States {
+		if len(state.CabRequests) != NFLOORS {
+			return fmt.Errorf("expected %d cab requests for %s, got %d", NFLOORS, id, len(state.CabRequests))
+		}
+	}
+	return nil
+}
+
+//Finds the newest good snapshot. A complete temporary file is newer than the snapshot and the ".bak" file, since
+//it is only left behind if the program stopped between writing it and moving it in place, and a half written one
+//fails its checksum
+func recoverSnapshot() (snapshotPayload, bool) {
+	path := backupPath()
+	candidates := []string{path + ".tmp", path, path + ".bak", legacyBackupPath}
+
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err != nil {
+			continue
+		}
+		payload, err := loadSnapshot(candidate)
+		if err != nil {
+			fmt.Println("Skipping backup file", candidate+":", err)
+			continue
+		}
+		fmt.Println("Loaded backup file", candidate)
+		return payload, true
+	}
+	return snapshotPayload{}, false
+}
@@ -0,0 +1,46 @@
+package ElevState
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//A new snapshot replaces the old one, which is kept as the .bak file, and a complete temporary file left behind by
+//a crash before the rename is taken over both
+func TestSnapshotKeepsPrevious(t *testing.T) {
+	NFLOORS = testFloors
+	BackupPath = filepath.Join(t.TempDir(), "states.txt")
+	defer func() { BackupPath = "" }()
+	snapshot := func(seq uint64) snapshotPayload {
+		states := newTestElevator("a", "a").states
+		return snapshotPayload{AllStates: states, JournalSeq: seq}
+	}
+
+	for seq := uint64(1); seq <= 3; seq++ {
+		if err := writeSnapshot(BackupPath, snapshot(seq)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, want := range map[string]uint64{BackupPath: 3, BackupPath + ".bak": 2} {
+		payload, err := loadSnapshot(path)
+		if err != nil || payload.JournalSeq != want {
+			t.Errorf("%s has journal sequence %d (%v), want %d", path, payload.JournalSeq, err, want)
+		}
+	}
+
+	//The program stopped after writing the temporary file, before moving it in place
+	if err := writeSnapshot(BackupPath+".next", snapshot(4)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(BackupPath+".next", BackupPath+".tmp"); err != nil {
+		t.Fatal(err)
+	}
+	if payload, ok := recoverSnapshot(); !ok || payload.JournalSeq != 4 {
+		t.Errorf("recovered journal sequence %d, want 4 from the temporary file", payload.JournalSeq)
+	}
+	os.WriteFile(BackupPath+".tmp", []byte(`{"version":1,"checksum":"00","payload":{}}`), 0644) //Half written
+	if payload, ok := recoverSnapshot(); !ok || payload.JournalSeq != 3 {
+		t.Errorf("recovered journal sequence %d, want 3 from the snapshot", payload.JournalSeq)
+	}
+}
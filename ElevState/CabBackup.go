@@ -0,0 +1,131 @@
+package ElevState
+
+/* CabBackup keeps a copy of the cab requests of every other elevator, taken from the NetworkMessages they send.
+The copies are sent along with every NetworkMessage from this elevator, so if an elevator loses its backup file or
+is replaced, it gets its cab requests back from its peers. For CabRecoveryTime after start-up an elevator is
+rejoining: it merges the copies the peers have of its cab requests into its own, and tells the peers that its own
+cab requests are not complete yet, so they merge them into their copy instead of replacing it. Until a peer's copy
+has arrived or the time is up, the elevator asks its peers for their copies in every message it sends (see
+Network/Network.go), and no hall requests are assigned and no orders are given to the FSM (see CabRecovered), so it
+doesn't start moving without the cab requests it had before.
+*/
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"../driver/elevio"
+)
+
+var CabRecoveryTime = 2 * time.Second //How long after start-up cab requests are recovered from the peers
+
+var cabBackups = make(map[string][]bool) //Copies of the cab requests of the other elevators, by ID
+var cabRecoveryUntil time.Time           //The time when the recovery of this elevator's cab requests ends
+var cabRecovered = make(chan struct{})   //Closed when a peer's copy has arrived or the recovery time is up
+var cabRecoveredOnce sync.Once
+
+//Makes sure the copies are not read and written at the same time
+var cabBackupMtx = sync.Mutex{}
+
+//Starts the time where cab requests are recovered from the peers, and loads the copies from the backup file
+func startCabRecovery(saved map[string][]bool) {
+	cabBackupMtx.Lock()
+	defer cabBackupMtx.Unlock()
+
+	for id, cabRequests := range saved {
+		if len(cabRequests) == NFLOORS && id != ID { //skips copies with the wrong number of floors
+			cabBackups[id] = cabRequests
+		}
+	}
+	cabRecoveryUntil = time.Now().Add(CabRecoveryTime)
+	time.AfterFunc(CabRecoveryTime, endCabRecoveryWait)
+}
+
+//Stops holding back the orders, when a peer's copy has arrived or the recovery time is up. Must be called after the
+//copy is merged into LocalAllStates, since DistributeOrders assigns LocalAllStates when the wait ends
+func endCabRecoveryWait() {
+	cabRecoveredOnce.Do(func() { close(cabRecovered) })
+}
+
+//Returns a channel that is closed when this elevator has got a peer's copy of its cab requests, or has given up
+//waiting for one. DistributeOrders waits for it before giving the FSM any orders
+func CabRecovered() <-chan struct{} {
+	return cabRecovered
+}
+
+//Returns true while this elevator is waiting for a peer's copy of its cab requests
+func AwaitingCabBackups() bool {
+	select {
+	case <-cabRecovered:
+		return false
+	default:
+		return true
+	}
+}
+
+//Returns true while this elevator is still recovering its cab requests from its peers
+func Rejoining() bool {
+	cabBackupMtx.Lock()
+	defer cabBackupMtx.Unlock()
+	return time.Now().Before(cabRecoveryUntil)
+}
+
+//Returns a copy of the cab requests this elevator keeps for the other elevators
+func CabBackupsCopy() map[string][]bool {
+	cabBackupMtx.Lock()
+	defer cabBackupMtx.Unlock()
+
+	backups := make(map[string][]bool)
+	for id, cabRequests := range cabBackups {
+		backups[id] = append([]bool{}, cabRequests...)
+	}
+	return backups
+}
+
+//Updates the copy of the cab requests of the elevator that sent the message. If the sender is rejoining, its
+//cab requests may be missing some that the copy has, so they are merged instead
+func storeCabBackup(message NetworkMessage) {
+	cabRequests := message.RemoteState.CabRequests
+	if message.ID == ID || len(cabRequests) != NFLOORS {
+		return
+	}
+
+	cabBackupMtx.Lock()
+	defer cabBackupMtx.Unlock()
+
+	stored, exists := cabBackups[message.ID]
+	if !message.Rejoining || !exists {
+		cabBackups[message.ID] = append([]bool{}, cabRequests...)
+		return
+	}
+	for floor := range stored {
+		stored[floor] = stored[floor] || cabRequests[floor]
+	}
+}
+
+//Returns true if the message has a copy of this elevator's cab requests
+func hasCabBackup(message NetworkMessage) bool {
+	backup, exists := message.CabBackups[ID]
+	return exists && len(backup) == NFLOORS
+}
+
+//Merges the copy of this elevator's cab requests in a message from a peer into states while rejoining.
+//Returns the updated states and the journal entries for the recovered cab requests
+func recoverCabRequests(message NetworkMessage, states AllStates) (AllStates, []JournalEntry) {
+	entries := []JournalEntry{}
+	if !hasCabBackup(message) || !Rejoining() {
+		return states, entries
+	}
+
+	for floor, requested := range message.CabBackups[ID] {
+		if requested && !states.States[ID].CabRequests[floor] {
+			states.States[ID].CabRequests[floor] = true
+			entries = append(entries, JournalEntry{Event: "ButtonPressed", Source: message.ID, PeerID: ID, Floor: floor, Button: elevio.BT_Cab})
+		}
+	}
+	if len(entries) > 0 {
+		fmt.Println("Recovered", len(entries), "cab requests from", message.ID)
+	}
+	return states, entries
+}
@@ -0,0 +1,58 @@
+package ElevState
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+//A rejoining elevator holds back its orders until a peer's copy of its cab requests has arrived, and the copy is in
+//LocalAllStates when the wait ends
+func TestCabRecoveryWaitsForCopy(t *testing.T) {
+	startTestDriver(t)
+	recoveryTime := CabRecoveryTime
+	CabRecoveryTime = time.Minute
+	defer func() {
+		CabRecoveryTime = recoveryTime
+		cabRecoveryUntil = time.Time{}
+		cabBackups = make(map[string][]bool)
+	}()
+
+	cabRecovered, cabRecoveredOnce = make(chan struct{}), sync.Once{} //Only made once when the program runs
+	a, b := newTestElevator("a", "a", "b"), newTestElevator("b", "a", "b")
+	a.use(func() { startCabRecovery(nil) })
+	if !AwaitingCabBackups() {
+		t.Fatal("not waiting for the cab requests right after start-up")
+	}
+
+	fromNetwork := make(chan NetworkMessage)
+	updated := make(chan AllStates, 10)
+	go UpdateFromNetwork(fromNetwork, updated)
+
+	//A message without a copy of a's cab requests doesn't end the wait
+	withoutCopy := b.message()
+	withoutCopy.CabBackups = map[string][]bool{}
+	a.use(func() {
+		fromNetwork <- withoutCopy
+		<-updated
+	})
+	if !AwaitingCabBackups() {
+		t.Fatal("stopped waiting on a message without a copy")
+	}
+
+	withCopy := b.message()
+	withCopy.Seq = withoutCopy.Seq + 1
+	withCopy.CabBackups = map[string][]bool{"a": {false, false, true, false}}
+	a.use(func() {
+		fromNetwork <- withCopy
+		<-updated
+	})
+	select {
+	case <-CabRecovered():
+	default:
+		t.Fatal("still waiting after the copy arrived")
+	}
+	if !a.states.States["a"].CabRequests[2] {
+		t.Errorf("a has cab requests %v, want the one at floor 2 from b's copy", a.states.States["a"].CabRequests)
+	}
+}
@@ -0,0 +1,50 @@
+package ElevState
+
+/* CarParameters lets every elevator tell the others how fast it is and which floors it serves, so a building can mix
+fast and slow cars, and cars that skip floors. The parameters are set at start-up and sent in the elevator's state,
+and DistributeOrders uses them for each car when it works out what a hall request costs. An elevator that doesn't
+send them is taken to have the default times and to serve every floor, like the elevators before they were added.
+*/
+
+import (
+	"time"
+)
+
+const DefaultTravelTime = 2500 * time.Millisecond //Time to travel between two floors, the same as in hall_request_assigner
+const DefaultDoorTime = 3000 * time.Millisecond   //Time the door is open at a floor, the same as in hall_request_assigner
+
+//The parameters of this elevator, set in main. CarServedFloors is nil if the elevator serves every floor
+var CarTravelTime = DefaultTravelTime
+var CarDoorTime = DefaultDoorTime
+var CarServedFloors []bool
+
+//Returns the time the car needs to travel between two floors
+func TravelTimeOf(state SingleStates) time.Duration {
+	if state.TravelTime <= 0 {
+		return DefaultTravelTime
+	}
+	return state.TravelTime
+}
+
+//Returns the time the car has its door open at a floor
+func DoorTimeOf(state SingleStates) time.Duration {
+	if state.DoorTime <= 0 {
+		return DefaultDoorTime
+	}
+	return state.DoorTime
+}
+
+//Returns true if the car stops at the floor and can take hall requests there
+func Serves(state SingleStates, floor int) bool {
+	return len(state.ServedFloors) == 0 || (floor < len(state.ServedFloors) && state.ServedFloors[floor])
+}
+
+//Sets this elevator's parameters in states
+func applyCarParameters(states AllStates) AllStates {
+	tmp := states.States[ID]
+	tmp.TravelTime = CarTravelTime
+	tmp.DoorTime = CarDoorTime
+	tmp.ServedFloors = CarServedFloors
+	states.States[ID] = tmp
+	return states
+}
@@ -0,0 +1,62 @@
+package ElevState
+
+/* Consistency carries the assignment digests between the elevators, so DistributeOrders can check that they agree
+on which car takes which hall call. Every elevator sends a hash of the states it assigned from and the owner of
+every hall call in its NetworkMessages, and keeps the latest digest from every peer.
+*/
+
+import (
+	"sync"
+	"time"
+)
+
+//Type of the summary of an assignment sent to the peers
+type AssignmentDigest struct {
+	InputHash string   `json:"inputHash"` //Hash of the states the assignment was made from
+	Owners    []string `json:"owners"`    //The car that has every hall call, at floor*2+button, empty for no call
+}
+
+var localDigest AssignmentDigest                    //The digest of this elevator's last assignment
+var peerDigests = make(map[string]AssignmentDigest) //The latest digest from every peer
+var digestTimes = make(map[string]time.Time)        //The time the latest digest from every peer was received
+
+//Makes sure the digests are not read and written at the same time
+var digestMtx = sync.Mutex{}
+
+//Sets the digest of this elevator's last assignment, sent in the following NetworkMessages
+func SetAssignmentDigest(digest AssignmentDigest) {
+	digestMtx.Lock()
+	defer digestMtx.Unlock()
+	localDigest = digest
+}
+
+//Returns the latest digest from the peer, and false if none has been received
+func PeerAssignmentDigest(id string) (AssignmentDigest, bool) {
+	digestMtx.Lock()
+	defer digestMtx.Unlock()
+	digest, exists := peerDigests[id]
+	return digest, exists
+}
+
+//Returns how long ago the latest digest from the peer was received
+func PeerDigestAge(id string) time.Duration {
+	digestMtx.Lock()
+	defer digestMtx.Unlock()
+	return time.Since(digestTimes[id])
+}
+
+//Keeps the digest from a peer's message
+func storeAssignmentDigest(message NetworkMessage) {
+	digestMtx.Lock()
+	defer digestMtx.Unlock()
+	if message.Assignment.InputHash != "" {
+		peerDigests[message.ID] = message.Assignment
+		digestTimes[message.ID] = time.Now()
+	}
+}
+
+func assignmentDigestCopy() AssignmentDigest {
+	digestMtx.Lock()
+	defer digestMtx.Unlock()
+	return localDigest
+}
@@ -0,0 +1,91 @@
+package ElevState
+
+/* CriticalEvents keeps track of the clear orders and new calls from every peer (see Network/Reliable.go) that this
+elevator has applied. The events come with every message from the peer until they are acknowledged, so the same event
+can arrive many times: newEvents returns only the ones after the last one applied, and they are recorded as applied
+after the message they came with is in LocalAllStates. The acknowledgements the Network module sends are taken from
+here, so a peer keeps sending an event until this elevator has taken the message with it, not just received it.
+
+The events don't carry any state. The hall orders travel in HallOrders and HallVersions (see HallOrders.go) and the
+cab requests in RemoteState, in every message. What the events make sure of is that the message sent right after a
+clear order or new call, with the state from after it, is applied by every peer.
+*/
+
+import (
+	"sync"
+)
+
+//Type that holds the counts of the events from the peers, see EventStatistics
+type EventStats struct {
+	Applied    uint64 //Events applied in order
+	Duplicates uint64 //Events that had been applied already
+	Skipped    uint64 //Events the peers gave up before they were applied here
+}
+
+var appliedEvents = make(map[string]CriticalAck) //The last event applied from every peer, by ID
+var eventStats EventStats
+
+//Makes sure the applied events are not read and written at the same time
+var eventsMtx = sync.Mutex{}
+
+//Returns the events in the message that come after the last one applied from the sender, in order
+func newEvents(message NetworkMessage) []CriticalEvent {
+	eventsMtx.Lock()
+	defer eventsMtx.Unlock()
+
+	last := appliedEvents[message.ID]
+	if last.Epoch != message.Epoch { //A new peer, or one that has restarted and numbers its events from 1 again
+		last = CriticalAck{}
+	}
+	events := []CriticalEvent{}
+	for _, event := range message.Events {
+		switch {
+		case event.Critical <= last.Critical:
+			eventStats.Duplicates++
+			continue
+		case event.Critical > last.Critical+1 && last.Critical > 0: //The peer gave up on the ones in between
+			eventStats.Skipped += event.Critical - 1 - last.Critical
+		}
+		last.Critical = event.Critical
+		events = append(events, event)
+	}
+	return events
+}
+
+//Records the events from the message as applied, so they are acknowledged. Must be called after the message is
+//in LocalAllStates
+func applyEvents(message NetworkMessage, events []CriticalEvent) {
+	if len(events) == 0 {
+		return
+	}
+	eventsMtx.Lock()
+	defer eventsMtx.Unlock()
+	appliedEvents[message.ID] = CriticalAck{PeerID: message.ID, Epoch: message.Epoch, Critical: events[len(events)-1].Critical}
+	eventStats.Applied += uint64(len(events))
+}
+
+//Forgets the events applied from a lost peer
+func forgetEvents(id string) {
+	eventsMtx.Lock()
+	defer eventsMtx.Unlock()
+	delete(appliedEvents, id)
+}
+
+//Returns the last event applied from every peer, for the acknowledgements the Network module sends
+func CriticalAcks() []CriticalAck {
+	eventsMtx.Lock()
+	defer eventsMtx.Unlock()
+
+	acks := []CriticalAck{}
+	for _, ack := range appliedEvents {
+		acks = append(acks, ack)
+	}
+	return acks
+}
+
+//Returns the counts of the events from the peers
+func EventStatistics() EventStats {
+	eventsMtx.Lock()
+	defer eventsMtx.Unlock()
+	return eventStats
+}
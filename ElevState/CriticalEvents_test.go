@@ -0,0 +1,61 @@
+package ElevState
+
+import (
+	"testing"
+)
+
+//An event is only acknowledged once the message it came with is taken, so one in a message that is dropped is
+//acknowledged when it comes again, and the events that come again after that are duplicates
+func TestEventsAcknowledgedWhenApplied(t *testing.T) {
+	startTestDriver(t)
+	forgetSender("c")
+	forgetEvents("c")
+	a, c := newTestElevator("a", "a", "c"), newTestElevator("c", "a", "c")
+	fromNetwork := make(chan NetworkMessage)
+	updated := make(chan AllStates, 10)
+	go UpdateFromNetwork(fromNetwork, updated)
+
+	clear1 := CriticalEvent{Critical: 1, MessageType: "ClearOrder", Floor: 1, ClearOrderDirection: "up"}
+	call2 := CriticalEvent{Critical: 2, MessageType: "NewCall", Floor: 2}
+	//Returns when UpdateFromNetwork has handled the message. A message it takes is sent on to DistributeOrders,
+	//and one it drops is handled when it receives the next one
+	send := func(seq uint64, taken bool, events ...CriticalEvent) {
+		message := c.message()
+		message.Epoch, message.Seq, message.Events = 1, seq, events
+		a.use(func() {
+			fromNetwork <- message
+			if taken {
+				<-updated
+			} else {
+				fromNetwork <- NetworkMessage{ID: "a"} //From itself, so it is ignored
+			}
+		})
+	}
+	ack := func() uint64 {
+		for _, ack := range CriticalAcks() {
+			if ack.PeerID == "c" && ack.Epoch == 1 {
+				return ack.Critical
+			}
+		}
+		return 0
+	}
+	before := EventStatistics()
+
+	send(5, true)
+	send(4, false, clear1) //Older than the last message, dropped with its event
+	if got := ack(); got != 0 {
+		t.Fatalf("acknowledged event %d from a message that was dropped", got)
+	}
+	send(6, true, clear1)
+	if got := ack(); got != 1 {
+		t.Fatalf("acknowledged event %d after the message with event 1 was taken, want 1", got)
+	}
+	send(7, true, clear1, call2)
+	if got := ack(); got != 2 {
+		t.Errorf("acknowledged event %d after the message with event 2 was taken, want 2", got)
+	}
+	stats := EventStatistics()
+	if applied, duplicates := stats.Applied-before.Applied, stats.Duplicates-before.Duplicates; applied != 2 || duplicates != 1 {
+		t.Errorf("applied %d events with %d duplicates, want 2 and 1", applied, duplicates)
+	}
+}
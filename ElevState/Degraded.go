@@ -0,0 +1,42 @@
+package ElevState
+
+/* Degraded marks an elevator whose assigner has failed, so it runs on the fallback assigner in DistributeOrders.
+The flag is sent in the elevator's state in every NetworkMessage, so the peers and anyone following the states can
+see which elevators are degraded.
+*/
+
+import (
+	"fmt"
+	"sync"
+)
+
+var degraded bool //True while DistributeOrders uses the fallback assigner
+
+//Makes sure the flag is not read and written at the same time
+var degradedMtx = sync.Mutex{}
+
+//Returns true if this elevator is degraded
+func Degraded() bool {
+	degradedMtx.Lock()
+	defer degradedMtx.Unlock()
+	return degraded
+}
+
+//Sets whether this elevator is degraded, and prints and publishes it when it changes
+func SetDegraded(isDegraded bool) {
+	degradedMtx.Lock()
+	changed := degraded != isDegraded
+	degraded = isDegraded
+	degradedMtx.Unlock()
+
+	if !changed {
+		return
+	}
+	if isDegraded {
+		fmt.Println("Assigner failed, running degraded on the fallback assigner")
+		publishFault(EV_Fault, ID, -1, "assigner")
+	} else {
+		fmt.Println("Assigner works again, no longer degraded")
+		publishFault(EV_FaultCleared, ID, -1, "assigner")
+	}
+}
@@ -0,0 +1,83 @@
+package ElevState
+
+/* DispatchPolicy makes all elevators use the same assigner in DistributeOrders. The policy is sent with every
+NetworkMessage, and an elevator takes the policy of a peer if it was set later than its own, so a policy switched
+on one elevator spreads to all of them. The policy given with -ASSIGNER at start-up has stamp 0, so if elevators
+are started with different ones, the one from the elevator with the lowest ID is used by all. A switched policy is
+saved in the snapshots and kept after a restart.
+*/
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+//Type of the policy that decides which assigner DistributeOrders uses
+type DispatchPolicy struct {
+	Name  string `json:"name"`  //Name of the assigner, see DistributeOrders/Assigner.go
+	Stamp int64  `json:"stamp"` //The time the policy was switched, in nanoseconds since 1970, or 0 if given at start-up
+	SetBy string `json:"setBy"` //ID of the elevator the policy was given to
+}
+
+var DefaultPolicy string         //Name of the assigner given at start-up, used until the policy is switched
+var currentPolicy DispatchPolicy //The policy this elevator uses
+
+//Makes sure the policy is not read and written at the same time
+var policyMtx = sync.Mutex{}
+
+//Returns true if policy a should be used instead of policy b. The one switched last wins, and if they were
+//switched at the same time the one from the elevator with the lowest ID wins, so all elevators choose the same
+func newerPolicy(a DispatchPolicy, b DispatchPolicy) bool {
+	if a.Stamp != b.Stamp {
+		return a.Stamp > b.Stamp
+	}
+	if a.SetBy != b.SetBy {
+		return a.SetBy < b.SetBy
+	}
+	return a.Name < b.Name
+}
+
+//Starts with the policy from the backup if it was switched at runtime, otherwise with the one given at start-up
+func initPolicy(saved DispatchPolicy) {
+	policyMtx.Lock()
+	defer policyMtx.Unlock()
+
+	currentPolicy = DispatchPolicy{Name: DefaultPolicy, SetBy: ID}
+	if saved.Stamp != 0 && saved.Name != "" {
+		currentPolicy = saved
+	}
+}
+
+//Returns the policy this elevator uses
+func CurrentPolicy() DispatchPolicy {
+	policyMtx.Lock()
+	defer policyMtx.Unlock()
+	return currentPolicy
+}
+
+//Switches to the assigner with the given name on all elevators. The name must be checked by the caller
+func SetDispatchPolicy(name string) DispatchPolicy {
+	policy := DispatchPolicy{Name: name, Stamp: time.Now().UnixNano(), SetBy: ID}
+	mergePolicy(policy)
+	return policy
+}
+
+//Takes a policy from a peer if it is newer than this elevator's, and returns true if it was taken
+func mergePolicy(policy DispatchPolicy) bool {
+	if policy.Name == "" { //The peer doesn't send a policy
+		return false
+	}
+
+	policyMtx.Lock()
+	if !newerPolicy(policy, currentPolicy) {
+		policyMtx.Unlock()
+		return false
+	}
+	currentPolicy = policy
+	policyMtx.Unlock()
+
+	fmt.Println("Dispatch policy is now", policy.Name, "set by", policy.SetBy)
+	Publish(Event{Type: EV_PolicyChanged, PeerID: policy.SetBy, Detail: policy.Name})
+	return true
+}
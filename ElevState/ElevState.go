@@ -9,21 +9,24 @@ and define most of the own defined struct's in this program.
 import (
 	"../Network/network/peers"
 	"../driver/elevio"
-	"encoding/json"
 	"fmt"
-	"os"
 	"sync"
+	"time"
 )
 
 //Type used to send information from the FSM to the ElevState
 type EventMessage struct {
 	EventType string
 	//"ClearOrder"
-	//"ReachFloor"
+	//"ReachNewFloor"
 	//"StartsDriving"
 	//"Stops"
 	//"MotorStopsWorking"
 	//"MotorWorksAgain"
+	//"DoorProblems"
+	//"DoorWorksAgain"
+	//"MaintenanceOn"
+	//"MaintenanceOff"
 	Floor               int
 	Behavior            string
 	Direction           string
@@ -38,22 +41,60 @@ type NetworkMessage struct {
 	//case "ClearOrder"
 	//case "MotorProblems"
 	//case "MotorWorksAgain"
+	//case "NewCall"
+	//case "CabBackupRequest"
 	RemoteState         SingleStates
 	HallRequests        [][2]bool
+	HallOrders          [][2]HallOrderState //The sender's state of every hall order, see HallOrders.go
+	HallVersions        [][2]uint64         //The sender's version of every hall order, see Reconcile.go
 	ClearOrderDirection string
+	CabBackups          map[string][]bool //The sender's copies of the other elevators' cab requests
+	Rejoining           bool              //True while the sender is still recovering its cab requests
+	Floors              int               //The number of floors the sender runs with
+	Epoch               int64             //The time the sender was started, in nanoseconds since 1970
+	Seq                 uint64            //Increased by the sender for every message, see Sequence.go
+	Policy              DispatchPolicy    //The assigner the sender uses, see DispatchPolicy.go
+	Assignment          AssignmentDigest  //The sender's last assignment, see Consistency.go
+	Codecs              []string          //The codecs the sender can decode, see Network/Codec.go
+	EventFloor          int               //The floor of the cleared order or new call
+	Events              []CriticalEvent   //The clear orders and new calls not acknowledged yet, see Network/Reliable.go
+	Acks                []CriticalAck     //The last event applied in order from every peer, see CriticalEvents.go
+}
+
+//Type that holds a clear order or new call that must reach every peer, see Network/Reliable.go. It only says what
+//happened, the state after it is in the message it comes with, see CriticalEvents.go
+type CriticalEvent struct {
+	Critical            uint64 //The number of the event, increased by the sender for every event
+	MessageType         string //ClearOrder or NewCall
+	Floor               int
+	ClearOrderDirection string
+}
+
+//Type that acknowledges the events received from a peer
+type CriticalAck struct {
+	PeerID   string
+	Epoch    int64  //The epoch of the peer
+	Critical uint64 //The number of the last event applied in order from it
 }
 
 //Type that contains the state information and cab request for one elevator
 type SingleStates struct {
-	Behavior    string `json:"behaviour"`
-	Floor       int    `json:"floor"`
-	Direction   string `json:"direction"`
-	CabRequests []bool `json:"cabRequests"`
+	Behavior     string        `json:"behaviour"`
+	Floor        int           `json:"floor"`
+	Direction    string        `json:"direction"`
+	CabRequests  []bool        `json:"cabRequests"`
+	Degraded     bool          `json:"degraded,omitempty"`     //True if the elevator uses the fallback assigner, see Degraded.go
+	Availability string        `json:"availability,omitempty"` //If the elevator can take hall requests, see Availability.go
+	TravelTime   time.Duration `json:"travelTime,omitempty"`   //Time to travel between two floors, see CarParameters.go
+	DoorTime     time.Duration `json:"doorTime,omitempty"`     //Time the door is open at a floor
+	ServedFloors []bool        `json:"servedFloors,omitempty"` //The floors the car serves, every floor if empty
 }
 
 //Type that contains states for all elevators on the network and hall requests
 type AllStates struct {
-	HallRequests [][2]bool               `json:"hallRequests"` // n x 2 matrix, n = number of floors
+	HallRequests [][2]bool               `json:"hallRequests"` // n x 2 matrix, n = number of floors, true for confirmed hall orders
+	HallOrders   [][2]HallOrderState     `json:"hallOrders"`   // n x 2 matrix, state of every hall order
+	HallVersions [][2]uint64             `json:"hallVersions"` // n x 2 matrix, version of every hall order
 	States       map[string]SingleStates `json:"states"`       //states of elevator with string id
 }
 
@@ -70,40 +111,47 @@ var Mtx = sync.Mutex{}
 func InitElevState() {
 	//Inits some of the different shared variables that we use in a "standard factory" condition
 	InitNew = SingleStates{Behavior: "idle", Floor: 0, Direction: "up", CabRequests: make([]bool, NFLOORS)}
-	LocalAllStates = AllStates{HallRequests: make([][2]bool, NFLOORS), States: make(map[string]SingleStates)}
+	LocalAllStates = AllStates{HallRequests: make([][2]bool, NFLOORS), HallOrders: make([][2]HallOrderState, NFLOORS), HallVersions: make([][2]uint64, NFLOORS), States: make(map[string]SingleStates)}
 	LocalAllStates.States[ID] = InitNew
-	ThisNetworkMessage = NetworkMessage{ID: ID, MessageType: "", RemoteState: InitNew, HallRequests: make([][2]bool, NFLOORS)} //Should make init function for this
+	ThisNetworkMessage = NetworkMessage{ID: ID, MessageType: "", Floors: NFLOORS, RemoteState: InitNew, HallRequests: make([][2]bool, NFLOORS), HallOrders: make([][2]HallOrderState, NFLOORS), HallVersions: make([][2]uint64, NFLOORS)} //Should make init function for this
 
 	//if statement that checks if it starts a new elevator, or recovers on program "crash"
-	if _, err := os.Stat("elevator_states.txt"); err == nil { //if the file exists, load it into LocalAllStates
-		fmt.Println("TRYING TO OPEN FILE")
-		data, err := os.Open("elevator_states.txt") //open the files
-		check(err)
-		defer data.Close() //makes sure it will be closed
-
-		tmp := new(AllStates) //makes a tmp to save the loaded data to
+	snapshotSeq := uint64(0)
+	savedCabBackups := map[string][]bool{}
+	savedPolicy := DispatchPolicy{}
+	if recovered, ok := recoverSnapshot(); ok { //if there is a good backup, load it into LocalAllStates
+		tmp := recovered.AllStates
+		if _, exists := tmp.States[ID]; !exists { //the backup may be from before this ID was used
+			tmp.States[ID] = InitNew
+		}
+		tmp = changeStateInAllStates(tmp, ID, "stop", 0, "idle") //Hall-orders and cab orders the same, rest initialized
+		//Faults are checked again by the FSM, but maintenance is kept
+		maintenance = tmp.States[ID].Availability == AV_Maintenance
+
+		LocalAllStates = tmp //Transfer the data to LocalAllStates
+		snapshotSeq = recovered.JournalSeq
+		savedCabBackups = recovered.CabBackups
+		savedPolicy = recovered.Policy
+		fmt.Println("Loaded LocalAllStates from file")
 
-		fileHandle := json.NewDecoder(data).Decode(tmp) //load file content into tmp
+	} else { //if there is no good backup, start from the initialized LocalAllStates
+		fmt.Println("No backup found, starting with empty states")
+	}
 
-		*tmp = changeStateInAllStates(*tmp, ID, "stop", 0, "idle") //Hall-orders and cab orders the same, rest initialized
+	LocalAllStates = applyAvailability(LocalAllStates)
+	LocalAllStates = applyCarParameters(LocalAllStates)
+	ThisNetworkMessage.RemoteState = LocalAllStates.States[ID]
 
-		LocalAllStates = *tmp //Transfer the data to LocalAllStates
-		check(fileHandle)
-		fmt.Println("Loaded LocalAllStates from file")
-		SetLights(LocalAllStates, ID)
-		fmt.Println("Finished ElevState INIT")
+	//Starts with the dispatch policy that was switched to before the restart, or the one given at start-up
+	initPolicy(savedPolicy)
 
-	} else { //if the file doesn't exist, create the file and initialize LocalAllStates
-		fmt.Println("TRYING TO CREAT FILE")
-		file, error := os.Create("elevator_states.txt") //Makes a new file
-		check(error)
+	//Starts recovering this elevator's cab requests from the peers, in case they are missing from the backup
+	startCabRecovery(savedCabBackups)
 
-		defer file.Close() //make sure it will be closed
-		tmp := LocalAllStates
-		savingFile(tmp, ID) //Saves the LocalAllStates to the file
-		fmt.Println("Finished ElevState INIT")
-
-	}
+	//Replays the events that happened after the snapshot was taken, the journal is kept for incident analysis
+	LocalAllStates = initJournal(LocalAllStates, snapshotSeq)
+	SetLights(LocalAllStates, ID)
+	fmt.Println("Finished ElevState INIT")
 
 }
 
@@ -114,27 +162,58 @@ func UpdateFromNetwork(PeerState <-chan NetworkMessage, UpdatedAllStates chan<-
 		case networkData := <-PeerState: //Receives peer data from the network
 			receivedID := networkData.ID
 
-			//Copy the share AllStates (LocalAllStates) variable to a one that is only used locally in this func (networkAllStates)
-			networkAllStates := copyAllState(LocalAllStates)
-
-			if receivedID != ID { //Only change data when it is not from it self to avoid outdated data
-				//Updates the state in networkAllStates variable for the received state, and adds any new hall requests
-				networkAllStates = updateAllStatesNetwork(networkData, networkAllStates)
-
-				switch TypeOfMessage := networkData.MessageType; TypeOfMessage { //checks what type of message it is
-
-				case "StateUpdate": //in this case it doesn't need to do anything new
+			//Only change data when it is not from it self to avoid outdated data. Drops messages that don't fit the number
+			//of floors, and messages that are older than the last one from the same peer, since they would roll its state backwards
+			if receivedID != ID && admitMessage(networkData) && acceptMessage(networkData) {
+				//Copy the share AllStates (LocalAllStates) variable to a one that is only used locally in this func (networkAllStates).
+				//Mtx is held until it is written back, so a button press or FSM event in between is not overwritten
+				Mtx.Lock()
+				networkAllStates := copyAllState(LocalAllStates)
+
+				//If the peer has just joined, its state is reconciled with this elevator's instead of just updated
+				reconcile := takeReconcile(receivedID)
+
+				//Takes the hall orders from the peer that are next in the cycle, and moves on the ones all peers have seen
+				journalEntries := mergeHallOrdersFromNetwork(networkAllStates, networkData, reconcile)
+
+				//Uses the peer's dispatch policy if it was switched later than this elevator's
+				mergePolicy(networkData.Policy)
+
+				//Keeps the peer's assignment, so DistributeOrders can check that they agree
+				storeAssignmentDigest(networkData)
+
+				//Keeps a copy of the peer's cab requests, and gets this elevator's cab requests back from it if rejoining
+				storeCabBackup(networkData)
+				var recoveredEntries []JournalEntry
+				networkAllStates, recoveredEntries = recoverCabRequests(networkData, networkAllStates)
+				journalEntries = append(journalEntries, recoveredEntries...)
+
+				//Updates the state in networkAllStates variable for the received state
+				previousState, known := networkAllStates.States[receivedID]
+				networkAllStates = updateAllStatesNetwork(networkData, networkAllStates, reconcile)
+				if known {
+					publishCarMoved(receivedID, previousState, networkData.RemoteState)
+				}
 
-				case "ClearOrder": //find the floor and direction the peer tells should be cleared
-					ClearFloor := networkData.RemoteState.Floor
-					ClearDirection := networkData.ClearOrderDirection
-					//Clears the order from hall requests in the networkAllStates variable
-					networkAllStates = clearFloorOrders(networkAllStates, ClearDirection, ClearFloor, receivedID)
+				//The clear orders and new calls the peer sent that are not applied yet, in order. They only come as events,
+				//the Network module sends the message they came with as a StateUpdate, see Network/Reliable.go. They carry
+				//no state, the hall orders are in HallOrders and the cab requests in RemoteState, which are taken above.
+				//Only the cleared cab requests are written to the journal
+				events := newEvents(networkData)
+				for _, event := range events {
+					if event.MessageType == "ClearOrder" && known && previousState.CabRequests[event.Floor] {
+						journalEntries = append(journalEntries, JournalEntry{Event: "OrderCleared", Source: receivedID, PeerID: receivedID, Floor: event.Floor, Button: elevio.BT_Cab})
+					}
 				}
-				//Saves to file, LocalALlStates, sets elevator lights, and sends the update to DistributeOrders
-				savingFile(networkAllStates, ID)
+				//Saves to the journal, LocalALlStates, sets elevator lights, and sends the update to DistributeOrders
+				journalEvents(networkAllStates, journalEntries...)
 				LocalAllStates = networkAllStates
 				SetLights(networkAllStates, ID)
+				Mtx.Unlock()
+				applyEvents(networkData, events)              //Acknowledged from now on, see CriticalEvents.go
+				if hasCabBackup(networkData) && Rejoining() { //The orders held back while rejoining can be given now, see CabBackup.go
+					endCabRecoveryWait()
+				}
 				UpdatedAllStates <- networkAllStates //send the updated AllStates to DistributedOrders
 
 			}
@@ -142,21 +221,44 @@ func UpdateFromNetwork(PeerState <-chan NetworkMessage, UpdatedAllStates chan<-
 	}
 }
 
-//Function that deletes peers from LocalAllStates if connection is lost/timmed out
+//Function that deletes peers from LocalAllStates if connection is lost/timmed out, and makes sure new peers are
+//reconciled with this elevator when their next message arrives
 func UpdatePeers(UpdatedPeers <-chan peers.PeerUpdate, UpdatedAllStates chan<- AllStates) {
 	for {
 		select {
 		case peers := <-UpdatedPeers:
+			markForReconcile([]string{peers.New})
+			if peers.New != "" && peers.New != ID {
+				Publish(Event{Type: EV_PeerJoined, PeerID: peers.New})
+			}
+
+			//Hall orders only have to be seen by the peers that are still on the network
+			Mtx.Lock()
+			journalEntries := updateLivePeers(LocalAllStates, peers.Peers, peers.Lost)
+			journalEvents(LocalAllStates, journalEntries...)
+			if len(journalEntries) > 0 { //Some hall orders were only waiting for the lost peers
+				SetLights(LocalAllStates, ID)
+			}
+			states := LocalAllStates
+			Mtx.Unlock()
+			if len(journalEntries) > 0 {
+				UpdatedAllStates <- states
+			}
+
 			for _, l := range peers.Lost { //checks the lost slice
 				if l != "" && l != ID { //deletes the lost peer form LocalAllStates
+					forgetSender(l)
+					forgetEvents(l)
 					Mtx.Lock()
 					delete(LocalAllStates.States, l) //Delete the lost peers
+					journalEvents(LocalAllStates, JournalEntry{Event: "PeerLost", Source: ID, PeerID: l})
+					states := LocalAllStates
 					Mtx.Unlock()
 
 					//if alone it needs to send information to DistributeOrders to redistribute order to itself
 					//When there is more than one this will happen automatically because the frequent NetworkMessages  -- Our solution to single elevator operation
 					if len(peers.Peers) == 1 {
-						UpdatedAllStates <- LocalAllStates
+						UpdatedAllStates <- states
 					}
 
 				}
@@ -171,26 +273,30 @@ func UpdateFromFSM(FSMEventMsg <-chan EventMessage, MsgToNetwork chan<- NetworkM
 		select {
 		case message := <-FSMEventMsg: //Event message from FSM
 			Event := message.EventType // to check what FSM event has happened
-			//Copy the share AllStates (LocalAllStates) varaible to a one that is only used locally in this func (fsmAllStates)
+			//Copy the share AllStates (LocalAllStates) varaible to a one that is only used locally in this func (fsmAllStates),
+			//with Mtx held until it is written back
+			Mtx.Lock()
 			fsmAllStates := copyAllState(LocalAllStates)
+			previousState := fsmAllStates.States[ID]
+			journalEntries := []JournalEntry{} //The changes to the orders that should be written to the journal
 
 			switch Event {
 			case "ClearOrder": //If the elevator has completed an order
 				//Make variables for floor and direction it should clear
 				floorToClear := message.Floor
 				directionToClear := message.ClearOrderDirection
-				//Set the Hall Request  to false on the given floor in the direction the elevator drives
-				fsmAllStates = clearFloorOrders(fsmAllStates, directionToClear, floorToClear, ID)
+				//Set the Hall Order to served on the given floor in the direction the elevator drives
+				fsmAllStates, journalEntries = clearFloorOrders(fsmAllStates, directionToClear, floorToClear, ID)
 				//Clear Cab Request for this elevator
 				fsmAllStates = changeStateInAllStates(fsmAllStates, ID, message.Direction, message.Floor, message.Behavior)
 				//And the updates to the Network Message
 
 				ThisNetworkMessage.MessageType = "ClearOrder"
 				ThisNetworkMessage.RemoteState = fsmAllStates.States[ID]
-				ThisNetworkMessage.HallRequests = fsmAllStates.HallRequests
 				ThisNetworkMessage.ClearOrderDirection = message.ClearOrderDirection
+				ThisNetworkMessage.EventFloor = message.Floor
 
-			case "ReachedNewFloor": //If it reaches a new floor but doesn't stop for order
+			case "ReachNewFloor": //If it reaches a new floor but doesn't stop for order
 				//Updates the local elevators state in fsmAllStates
 				fsmAllStates = changeStateInAllStates(fsmAllStates, ID, message.Direction, message.Floor, message.Behavior)
 				//Update the Network Message
@@ -214,24 +320,56 @@ func UpdateFromFSM(FSMEventMsg <-chan EventMessage, MsgToNetwork chan<- NetworkM
 			case "MotorProblems": //When the elevators motor is not working
 				//Updates the local elevators state in fsmAllStates
 				fsmAllStates = changeStateInAllStates(fsmAllStates, ID, message.Direction, message.Floor, message.Behavior)
+				motorFault = true
 				//Update the Network Message
 				ThisNetworkMessage.MessageType = "MotorProblems"
 				ThisNetworkMessage.RemoteState = fsmAllStates.States[ID]
+				publishFault(EV_Fault, ID, message.Floor, "motor")
 
 			case "MotorWorksAgain": //When the elevator has reached a point where it know the motor is working again
 				//Updates the local elevators state in fsmAllStates
 				fsmAllStates = changeStateInAllStates(fsmAllStates, ID, message.Direction, message.Floor, message.Behavior)
+				motorFault = false
 				//Update the Network Message
 				ThisNetworkMessage.MessageType = "MotorWorksAgain"
 				ThisNetworkMessage.RemoteState = fsmAllStates.States[ID]
+				publishFault(EV_FaultCleared, ID, message.Floor, "motor")
+
+			case "DoorProblems": //When the door has been obstructed for too long
+				fsmAllStates = changeStateInAllStates(fsmAllStates, ID, message.Direction, message.Floor, message.Behavior)
+				doorFault = true
+				ThisNetworkMessage.MessageType = "StateUpdate"
+				publishFault(EV_Fault, ID, message.Floor, "door")
+
+			case "DoorWorksAgain": //When the obstruction is gone
+				fsmAllStates = changeStateInAllStates(fsmAllStates, ID, message.Direction, message.Floor, message.Behavior)
+				doorFault = false
+				ThisNetworkMessage.MessageType = "StateUpdate"
+				publishFault(EV_FaultCleared, ID, message.Floor, "door")
+
+			case "MaintenanceOn", "MaintenanceOff": //Switched from the terminal, the state of the elevator is not changed
+				maintenance = Event == "MaintenanceOn"
+				ThisNetworkMessage.MessageType = "StateUpdate"
+				if maintenance {
+					publishFault(EV_Fault, ID, fsmAllStates.States[ID].Floor, "maintenance")
+				} else {
+					publishFault(EV_FaultCleared, ID, fsmAllStates.States[ID].Floor, "maintenance")
+				}
 			}
+			//The availability is sent with the state, so the peers stop giving this elevator hall requests while it is faulty
+			fsmAllStates = applyAvailability(fsmAllStates)
+			fsmAllStates = applyCarParameters(fsmAllStates)
+			ThisNetworkMessage.RemoteState = fsmAllStates.States[ID]
 			if len(fsmAllStates.States) == 1 { //Sets lights after FSM event if it is the only elevator on network
 				SetLights(fsmAllStates, ID)
 			}
-			//Saves to file, LocalALlStates, and sends the update to DistributeOrders and Network
-			savingFile(fsmAllStates, ID)
+			publishCarMoved(ID, previousState, fsmAllStates.States[ID])
+			//Saves to the journal, LocalALlStates, and sends the update to DistributeOrders and Network
+			journalEvents(fsmAllStates, journalEntries...)
 			LocalAllStates = fsmAllStates
-			MsgToNetwork <- ThisNetworkMessage
+			networkMessage := ThisNetworkMessage //Sent after Mtx is released, so a copy is taken
+			Mtx.Unlock()
+			MsgToNetwork <- networkMessage
 			UpdatedAllStates <- fsmAllStates
 		}
 	}
@@ -247,66 +385,66 @@ func UpdateOrders(UpdatedAllStates chan<- AllStates, MsgToNetwork chan<- Network
 	for {
 		select {
 		case NewOrderLocal := <-buttonPressed: //When a button is pressed
-			//Copy the share AllStates (LocalAllStates) varaible to a one that is only used locally in this func (networkAllStates)
+			//Copy the share AllStates (LocalAllStates) varaible to a one that is only used locally in this func (networkAllStates),
+			//with Mtx held until it is written back
+			Mtx.Lock()
 			buttonAllStates = copyAllState(LocalAllStates)
 			//Check what type of button was pressed
+			journalEntries := []JournalEntry{}
 			switch ButtonType := NewOrderLocal.Button; ButtonType {
-			case 0: //up, Sets hall request up for right floor to true
-				buttonAllStates.HallRequests[NewOrderLocal.Floor][0] = true
-			case 1: //down, Sets hall request down for right floor to true
-				buttonAllStates.HallRequests[NewOrderLocal.Floor][1] = true
-			case 2: //cab, Sets cab request for right floor to true
+			case elevio.BT_HallUp, elevio.BT_HallDown: //Makes the hall order unconfirmed until all peers have seen it
+				journalEntries = pressHallButton(buttonAllStates, NewOrderLocal.Floor, ButtonType)
+			case elevio.BT_Cab: //cab, Sets cab request for right floor to true
 				buttonAllStates.States[ID].CabRequests[NewOrderLocal.Floor] = true
+				journalEntries = append(journalEntries, JournalEntry{Event: "ButtonPressed", Source: ID, PeerID: ID, Floor: NewOrderLocal.Floor, Button: ButtonType})
 			}
 
-			//Saves to file, LocalALlStates, and sends the update to DistributeOrders and Network
-			ThisNetworkMessage.MessageType = "StateUpdate" //"This elevator has had an update in its state!"
-			ThisNetworkMessage.HallRequests = buttonAllStates.HallRequests
+			//Saves to the journal, LocalALlStates, and sends the update to DistributeOrders and Network
+			ThisNetworkMessage.MessageType = "NewCall" //"This elevator has had a new call!" The peers must get it, see Network/Reliable.go
+			ThisNetworkMessage.EventFloor = NewOrderLocal.Floor
 			ThisNetworkMessage.RemoteState = buttonAllStates.States[ID]
 
 			if len(buttonAllStates.States) == 1 { //Sets lights after FSM event if it is the only elevator on network -- Single elevator operation
 				SetLights(buttonAllStates, ID)
 			}
 
-			savingFile(buttonAllStates, ID)
+			journalEvents(buttonAllStates, journalEntries...)
 			LocalAllStates = buttonAllStates
-			MsgToNetwork <- ThisNetworkMessage
+			networkMessage := ThisNetworkMessage //Sent after Mtx is released, so a copy is taken
+			Mtx.Unlock()
+			MsgToNetwork <- networkMessage
 			UpdatedAllStates <- buttonAllStates
 
 		}
 	}
 }
 
-func check(e error) { //check for file error
-	if e != nil {
-		panic(e)
-	}
-}
-
-// Updatees  the local AllStates when getting information from Network
-func updateAllStatesNetwork(statesFromNetwork NetworkMessage, currentAllStates AllStates) AllStates {
+// Updatees  the local AllStates when getting information from Network. Peers that are not in AllStates are only
+// added when addPeer is true, so a late message from a lost peer doesn't add it back
+func updateAllStatesNetwork(statesFromNetwork NetworkMessage, currentAllStates AllStates, addPeer bool) AllStates {
 	receivedID := statesFromNetwork.ID // retrieved the received ID
 
-	for peer := range currentAllStates.States { //Loop through all peers in the AllStates variable
-		if peer == receivedID { //Find the one that matches the receivedID
-			currentAllStates.States[receivedID] = statesFromNetwork.RemoteState //Update that elevators states
-			//Checks for hall requests and set the local ones to true if the received ones were true
-
-			for floor := range statesFromNetwork.HallRequests {
-				if statesFromNetwork.HallRequests[floor][0] { //clear hall request up - 0
-					currentAllStates.HallRequests[floor][0] = true
-				}
-				if statesFromNetwork.HallRequests[floor][1] { ////clear hall request down - 1
-					currentAllStates.HallRequests[floor][1] = true
-				}
-			}
-		}
+	if _, exists := currentAllStates.States[receivedID]; exists || addPeer {
+		currentAllStates.States[receivedID] = statesFromNetwork.RemoteState //Update that elevators states
+		//The hall requests are not taken directly, they are agreed on with the hall orders
 	}
 
 	SetLights(currentAllStates, ID) //Set the lights of the elevators
 	return currentAllStates         //returns the updated AllStates
 }
 
+//Fills in the parts of a NetworkMessage that change without this elevator sending a new message, so that the
+//resends from the Network module always contain the newest hall orders and copies of the peers' cab requests
+func FreshNetworkMessage(message NetworkMessage) NetworkMessage {
+	message.HallOrders, message.HallVersions, message.HallRequests = hallOrdersCopy()
+	message.CabBackups = CabBackupsCopy()
+	message.Rejoining = Rejoining()
+	message.Policy = CurrentPolicy()
+	message.RemoteState.Degraded = Degraded()
+	message.Assignment = assignmentDigestCopy()
+	return message
+}
+
 //Sets elevator lights based on hall requests and cab requests
 func SetLights(states AllStates, id string) {
 	for floor := 0; floor < NFLOORS; floor++ { //loop through and checks all
@@ -317,15 +455,6 @@ func SetLights(states AllStates, id string) {
 
 }
 
-// function that saves the states and hall requests of the elevator
-func savingFile(states AllStates, ID string) {
-	file, err := os.Create("elevator_states.txt") //Creates file that will only contain latest data
-	//checks for errors and saves to file as JSON
-	check(err)
-	e := json.NewEncoder(file).Encode(states) //saves the AllStates struct to file
-	check(e)
-}
-
 //function that will change one of the states in AllStates
 func changeStateInAllStates(states AllStates, id string, dir string, floor int, behavior string) AllStates {
 	//makes temp that can have changes
@@ -339,16 +468,14 @@ func changeStateInAllStates(states AllStates, id string, dir string, floor int,
 	return states
 }
 
-//Clear orders depending on the order direction
-func clearFloorOrders(state AllStates, direction string, floor int, id string) AllStates {
-	if direction == "up" { //up - 0
-		state.HallRequests[floor][0] = false
-	}
-	if direction == "down" { //down - 1
-		state.HallRequests[floor][1] = false
+//Clear orders depending on the order direction, returns the journal entries for the cleared orders
+func clearFloorOrders(state AllStates, direction string, floor int, id string) (AllStates, []JournalEntry) {
+	entries := serveHallOrder(state, floor, direction) //Served hall orders are cleared when all peers have seen it
+	if state.States[id].CabRequests[floor] {
+		state.States[id].CabRequests[floor] = false
+		entries = append(entries, JournalEntry{Event: "OrderCleared", Source: id, PeerID: id, Floor: floor, Button: elevio.BT_Cab})
 	}
-	state.States[id].CabRequests[floor] = false
-	return state
+	return state, entries
 }
 
 //copies the content of an AllState type and returns it
@@ -356,6 +483,8 @@ func copyAllState(original AllStates) AllStates {
 	//makes a temporary AllStates and extract the values from the input into it
 	new := AllStates{}
 	new.HallRequests = original.HallRequests
+	new.HallOrders = original.HallOrders
+	new.HallVersions = original.HallVersions
 	new.States = make(map[string]SingleStates)
 
 	for key, state := range original.States { // range through all the states and add them to the new AllStates
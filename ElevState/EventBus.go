@@ -0,0 +1,121 @@
+package ElevState
+
+/* EventBus lets other parts of the program follow what happens to the elevators without being wired into the
+channels between the modules. Anything can Subscribe to get a channel of typed events, and the modules Publish
+events when calls are registered, assigned and served, when cars move, when peers join or are lost and on faults.
+Publishing never blocks: if a subscriber's channel is full, the event is dropped for that subscriber and counted.
+*/
+
+import (
+	"sync"
+	"time"
+
+	"../driver/elevio"
+)
+
+type EventType string
+
+const (
+	EV_CallRegistered EventType = "CallRegistered" //A hall call is confirmed by all peers, or a cab call is pressed
+	EV_CallAssigned   EventType = "CallAssigned"   //A hall call is assigned to a car
+	EV_CallServed     EventType = "CallServed"     //A car has served a call
+	EV_CarMoved       EventType = "CarMoved"       //A car has changed floor, behaviour or direction
+	EV_PeerJoined     EventType = "PeerJoined"     //A peer has joined the network
+	EV_PeerLost       EventType = "PeerLost"       //A peer is lost from the network
+	EV_Fault          EventType = "Fault"          //A car has a fault, or a peer is quarantined
+	EV_FaultCleared   EventType = "FaultCleared"   //A car's fault is gone
+	EV_PolicyChanged  EventType = "PolicyChanged"  //The dispatch policy has been switched
+)
+
+//Type of the events sent to subscribers
+type Event struct {
+	Type   EventType
+	Time   time.Time
+	PeerID string            //The car or peer the event is about
+	Floor  int               //For calls and cars
+	Button elevio.ButtonType //For calls
+	Detail string            //Behaviour and direction of a moved car, what the fault is, or the new policy
+}
+
+var subscribers = make(map[int]chan Event) //The channels of the subscribers, by subscription number
+var nextSubscription = 0                   //The number of the next subscription
+var droppedEvents uint64                   //The number of events dropped because a subscriber was full
+
+//Makes sure subscribers are not added and removed while events are published
+var busMtx = sync.Mutex{}
+
+//Subscribes to all events. Returns a channel with room for buffer events, and a function that ends the subscription
+func Subscribe(buffer int) (<-chan Event, func()) {
+	busMtx.Lock()
+	defer busMtx.Unlock()
+
+	events := make(chan Event, buffer)
+	subscription := nextSubscription
+	nextSubscription++
+	subscribers[subscription] = events
+
+	unsubscribe := func() {
+		busMtx.Lock()
+		defer busMtx.Unlock()
+		if _, exists := subscribers[subscription]; exists {
+			delete(subscribers, subscription)
+			close(events)
+		}
+	}
+	return events, unsubscribe
+}
+
+//Sends an event to all subscribers. The time is set if it is missing
+func Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	busMtx.Lock()
+	defer busMtx.Unlock()
+	for _, events := range subscribers {
+		select {
+		case events <- event:
+		default:
+			droppedEvents++
+		}
+	}
+}
+
+//Returns the number of events dropped because a subscriber's channel was full
+func DroppedEvents() uint64 {
+	busMtx.Lock()
+	defer busMtx.Unlock()
+	return droppedEvents
+}
+
+//Publishes the events for journal entries: calls registered and served, and peers lost
+func publishJournalEntries(entries []JournalEntry) {
+	for _, entry := range entries {
+		event := Event{Time: entry.Time, PeerID: entry.PeerID, Floor: entry.Floor, Button: entry.Button}
+		switch {
+		case entry.Event == "OrderConfirmed", entry.Event == "ButtonPressed" && entry.Button == elevio.BT_Cab:
+			event.Type = EV_CallRegistered
+		case entry.Event == "OrderCleared":
+			event.Type = EV_CallServed
+			event.PeerID = entry.Source
+		case entry.Event == "PeerLost":
+			event.Type = EV_PeerLost
+		default:
+			continue
+		}
+		Publish(event)
+	}
+}
+
+//Publishes a CarMoved event if the car id has changed floor, behaviour or direction
+func publishCarMoved(id string, before SingleStates, after SingleStates) {
+	if before.Floor != after.Floor || before.Behavior != after.Behavior || before.Direction != after.Direction {
+		Publish(Event{Type: EV_CarMoved, PeerID: id, Floor: after.Floor, Detail: after.Behavior + " " + after.Direction})
+	}
+}
+
+//Publishes a Fault or FaultCleared event for the car id
+func publishFault(eventType EventType, id string, floor int, detail string) {
+	Publish(Event{Type: eventType, PeerID: id, Floor: floor, Detail: detail})
+}
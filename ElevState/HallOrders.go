@@ -0,0 +1,262 @@
+package ElevState
+
+/* HallOrders makes the elevators agree on the hall requests. Every hall button has a cyclic counter that goes
+none -> unconfirmed -> confirmed -> served -> none. A button press makes it unconfirmed, and it only becomes
+confirmed (and is lit and given to DistributeOrders) when every live peer has seen it as unconfirmed. When an
+elevator serves the order it becomes served, and it goes back to none when every live peer has seen it as served.
+An elevator only takes the state of a peer if it is the next one in the cycle, so an old message from a peer that
+hasn't seen the order being served can't turn it back on, and a press is kept by the peers even if the elevator
+where it was pressed dies before it is confirmed. Every press also gives the order a new version, which is used to
+tell orders apart when peers that have been apart merge their hall orders, see Reconcile.go. The versions also keep
+the cycle going when messages are lost: a peer that is further along with the same order is followed even if it is
+more than one state ahead, and a peer that has a newer order has seen the old one through.
+*/
+
+import (
+	"sync"
+
+	"../driver/elevio"
+)
+
+type HallOrderState int
+
+const (
+	HO_None        HallOrderState = 0
+	HO_Unconfirmed HallOrderState = 1
+	HO_Confirmed   HallOrderState = 2
+	HO_Served      HallOrderState = 3
+)
+
+var hallViews = make(map[string][][2]HallOrderState) //The last hall orders received from each peer
+var hallVersionViews = make(map[string][][2]uint64)  //The versions of the last hall orders received from each peer
+var livePeers []string                               //The peers currently on the network, from the peers module
+var pendingHallPresses [][2]bool                     //Presses on buttons that were served, waiting for them to become none
+
+//Makes sure the hall orders are only changed by one function at the time
+var hallMtx = sync.Mutex{}
+
+//Returns the state that comes after the given one in the cycle
+func nextHallOrderState(state HallOrderState) HallOrderState {
+	return (state + 1) % 4
+}
+
+//Makes a copy of hall orders
+func copyHallOrders(orders [][2]HallOrderState) [][2]HallOrderState {
+	return append([][2]HallOrderState{}, orders...)
+}
+
+//Makes a copy of hall order versions
+func copyHallVersions(versions [][2]uint64) [][2]uint64 {
+	return append([][2]uint64{}, versions...)
+}
+
+//Makes hall orders from hall requests, used for backups from before the hall orders existed
+func hallOrdersFromRequests(requests [][2]bool) [][2]HallOrderState {
+	orders := make([][2]HallOrderState, len(requests))
+	for floor := range requests {
+		for button := 0; button < 2; button++ {
+			if requests[floor][button] {
+				orders[floor][button] = HO_Confirmed
+			}
+		}
+	}
+	return orders
+}
+
+//Sets the hall requests to the confirmed hall orders. Served orders are not requests anymore
+func setHallRequests(states AllStates) {
+	for floor := range states.HallOrders {
+		for button := 0; button < 2; button++ {
+			states.HallRequests[floor][button] = states.HallOrders[floor][button] == HO_Confirmed
+		}
+	}
+}
+
+//Takes the state of every button in remote that is the next in the cycle from the local one and not from an older
+//order, or that is further along with the same order, that is the same version. A press is only taken if it is newer
+//than the order that was reset. When the states are the same the highest version is kept, so all peers
+//end up with the same version for the same order
+func mergeHallOrders(local [][2]HallOrderState, localVersions [][2]uint64, remote [][2]HallOrderState, remoteVersions [][2]uint64) {
+	if len(remote) != len(local) {
+		return
+	}
+	for floor := range local {
+		for button := 0; button < 2; button++ {
+			next, further := remote[floor][button] == nextHallOrderState(local[floor][button]), false
+			if len(remoteVersions) == len(local) { //The next state of an older order, or a press of a reset one, is not taken
+				newer, same := remoteVersions[floor][button] > localVersions[floor][button], remoteVersions[floor][button] == localVersions[floor][button]
+				next = next && (newer || (same && local[floor][button] != HO_None))
+				further = localVersions[floor][button] != 0 && remoteVersions[floor][button] == localVersions[floor][button] &&
+					hallOrderRank(remote[floor][button]) > hallOrderRank(local[floor][button])
+			}
+			if next || further {
+				local[floor][button] = remote[floor][button]
+			}
+			if len(remoteVersions) == len(local) && local[floor][button] == remote[floor][button] && remoteVersions[floor][button] > localVersions[floor][button] {
+				localVersions[floor][button] = remoteVersions[floor][button]
+			}
+		}
+	}
+}
+
+//Moves unconfirmed and served orders of the elevator id on when every live peer has seen them, that is when their
+//view of the order is the same as the local one or has already moved on to the next state. A served order has also
+//been seen by the peers that have a newer order, since they have reset it
+func advanceHallOrders(id string, local [][2]HallOrderState, versions [][2]uint64, views map[string][][2]HallOrderState,
+	versionViews map[string][][2]uint64, live []string, pending [][2]bool) {
+	for floor := range local {
+		for button := 0; button < 2; button++ {
+			state := local[floor][button]
+			if state == HO_Unconfirmed || state == HO_Served {
+				acknowledged := true
+				for _, peer := range live {
+					if peer == id {
+						continue
+					}
+					view, exists := views[peer]
+					if !exists || len(view) != len(local) {
+						acknowledged = false
+						break
+					}
+					seen := view[floor][button] == state || view[floor][button] == nextHallOrderState(state)
+					if versionView := versionViews[peer]; len(versionView) == len(local) { //A view of another order doesn't count
+						newer := state == HO_Served && versionView[floor][button] > versions[floor][button]
+						seen = (seen && versionView[floor][button] == versions[floor][button]) || newer
+					}
+					if !seen {
+						acknowledged = false
+						break
+					}
+				}
+				if acknowledged {
+					local[floor][button] = nextHallOrderState(state)
+				}
+			}
+
+			//A press on a served button is kept until the button is none, then it is taken as a new press. It gets a new
+			//version whether the order was reset here or taken as none from a peer, or the peers would not take it
+			if local[floor][button] == HO_None && floor < len(pending) && pending[floor][button] {
+				local[floor][button] = HO_Unconfirmed
+				versions[floor][button] = newHallOrderVersion(versions, id)
+				pending[floor][button] = false
+			}
+		}
+	}
+}
+
+//Makes the journal entries for the hall orders that changed, with the elevator that caused the change as source
+func hallOrderEntries(before [][2]HallOrderState, after [][2]HallOrderState, versions [][2]uint64, source string) []JournalEntry {
+	entries := []JournalEntry{}
+	for floor := range after {
+		for _, button := range []elevio.ButtonType{elevio.BT_HallUp, elevio.BT_HallDown} {
+			state := after[floor][button]
+			if state == before[floor][button] {
+				continue
+			}
+			event := "OrderReset"
+			switch state {
+			case HO_Unconfirmed:
+				event = "ButtonPressed"
+			case HO_Confirmed:
+				event = "OrderConfirmed"
+			case HO_Served:
+				event = "OrderCleared"
+			}
+			entries = append(entries, JournalEntry{Event: event, Source: source, Floor: floor, Button: button, State: state, Version: versions[floor][button]})
+		}
+	}
+	return entries
+}
+
+//Applies change to the hall orders in states, moves them on if every live peer has seen them, and updates
+//the hall requests. Returns the journal entries for the changed hall orders
+func changeHallOrders(states AllStates, source string, change func(orders [][2]HallOrderState, versions [][2]uint64)) []JournalEntry {
+	hallMtx.Lock()
+	defer hallMtx.Unlock()
+
+	if len(pendingHallPresses) != len(states.HallOrders) {
+		pendingHallPresses = make([][2]bool, len(states.HallOrders))
+	}
+	before := copyHallOrders(states.HallOrders)
+	change(states.HallOrders, states.HallVersions)
+	advanceHallOrders(ID, states.HallOrders, states.HallVersions, hallViews, hallVersionViews, activePeers(), pendingHallPresses)
+	setHallRequests(states)
+	return hallOrderEntries(before, states.HallOrders, states.HallVersions, source)
+}
+
+//Registers a press on a hall button
+func pressHallButton(states AllStates, floor int, button elevio.ButtonType) []JournalEntry {
+	return changeHallOrders(states, ID, func(orders [][2]HallOrderState, versions [][2]uint64) {
+		switch orders[floor][button] {
+		case HO_None:
+			orders[floor][button] = HO_Unconfirmed
+			versions[floor][button] = newHallOrderVersion(versions, ID)
+		case HO_Served:
+			pendingHallPresses[floor][button] = true
+		}
+	})
+}
+
+//Registers that this elevator has served the hall order in the given direction ("up", "down" or "noHall")
+func serveHallOrder(states AllStates, floor int, direction string) []JournalEntry {
+	return changeHallOrders(states, ID, func(orders [][2]HallOrderState, versions [][2]uint64) {
+		button := -1
+		switch direction {
+		case "up":
+			button = int(elevio.BT_HallUp)
+		case "down":
+			button = elevio.BT_HallDown
+		}
+		if button >= 0 && orders[floor][button] == HO_Confirmed {
+			orders[floor][button] = HO_Served
+		}
+	})
+}
+
+//Stores the hall orders in a message from a peer as its view, and takes the states that are next in the cycle.
+//If the peer has just joined, the hall orders are reconciled instead, since they may have changed on both sides
+func mergeHallOrdersFromNetwork(states AllStates, message NetworkMessage, reconcile bool) []JournalEntry {
+	return changeHallOrders(states, message.ID, func(orders [][2]HallOrderState, versions [][2]uint64) {
+		if len(message.HallOrders) != len(orders) {
+			return
+		}
+		if reconcile {
+			reconcileHallOrders(orders, versions, message.HallOrders, message.HallVersions)
+		}
+		hallViews[message.ID] = copyHallOrders(message.HallOrders)
+		hallVersionViews[message.ID] = copyHallVersions(message.HallVersions)
+		mergeHallOrders(orders, versions, message.HallOrders, message.HallVersions)
+	})
+}
+
+//Updates which peers have to see a hall order before it moves on. Lost peers don't have to, so this can move orders on
+func updateLivePeers(states AllStates, peers []string, lost []string) []JournalEntry {
+	return changeHallOrders(states, ID, func(orders [][2]HallOrderState, versions [][2]uint64) {
+		livePeers = append([]string{}, peers...)
+		for _, peer := range lost {
+			delete(hallViews, peer)
+			delete(hallVersionViews, peer)
+		}
+	})
+}
+
+//Returns the live peers that are not quarantined, they are the ones that have to see hall orders before they move on
+func activePeers() []string {
+	active := []string{}
+	for _, peer := range livePeers {
+		if !isQuarantined(peer) {
+			active = append(active, peer)
+		}
+	}
+	return active
+}
+
+//Returns a copy of this elevator's hall orders, their versions and the hall requests, for sending to the peers.
+//Mtx is taken first, like the update functions in ElevState.go do, since they replace LocalAllStates
+func hallOrdersCopy() ([][2]HallOrderState, [][2]uint64, [][2]bool) {
+	Mtx.Lock()
+	defer Mtx.Unlock()
+	hallMtx.Lock()
+	defer hallMtx.Unlock()
+	return copyHallOrders(LocalAllStates.HallOrders), copyHallVersions(LocalAllStates.HallVersions), append([][2]bool{}, LocalAllStates.HallRequests...)
+}
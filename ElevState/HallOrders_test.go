@@ -0,0 +1,268 @@
+package ElevState
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"../driver/elevio"
+)
+
+const testFloors = 4
+
+//Returns hall orders for the test floors with the given button set to the state
+func hallOrdersWith(floor int, button int, state HallOrderState) [][2]HallOrderState {
+	orders := make([][2]HallOrderState, testFloors)
+	orders[floor][button] = state
+	return orders
+}
+
+//A peer only takes the state that comes next in the cycle for the same order, a new press, or the state of a peer
+//that is further along with the same order, and the highest version of the state both have
+func TestMergeHallOrders(t *testing.T) {
+	const version = 1 << 16
+	cases := []struct {
+		name          string
+		local, remote HallOrderState
+		remoteVersion uint64
+		want          HallOrderState
+	}{
+		{"press", HO_None, HO_Unconfirmed, version + 1, HO_Unconfirmed},
+		{"confirm", HO_Unconfirmed, HO_Confirmed, version, HO_Confirmed},
+		{"serve", HO_Confirmed, HO_Served, version, HO_Served},
+		{"reset", HO_Served, HO_None, version, HO_None},
+		{"old unconfirmed after serve", HO_Served, HO_Unconfirmed, version, HO_Served},
+		{"old confirmed after reset", HO_None, HO_Confirmed, version, HO_None},
+		{"old unconfirmed after confirm", HO_Confirmed, HO_Unconfirmed, version, HO_Confirmed},
+		{"old unconfirmed after reset", HO_None, HO_Unconfirmed, version, HO_None},
+		{"confirmed older order", HO_Unconfirmed, HO_Confirmed, version - 1, HO_Unconfirmed},
+		{"served same order", HO_Unconfirmed, HO_Served, version, HO_Served},
+		{"served older order", HO_Unconfirmed, HO_Served, version - 1, HO_Unconfirmed},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			local, remote := hallOrdersWith(2, 1, c.local), hallOrdersWith(2, 1, c.remote)
+			localVersions, remoteVersions := make([][2]uint64, testFloors), make([][2]uint64, testFloors)
+			localVersions[2][1], remoteVersions[2][1] = version, c.remoteVersion
+			mergeHallOrders(local, localVersions, remote, remoteVersions)
+			if local[2][1] != c.want {
+				t.Errorf("got %d, want %d", local[2][1], c.want)
+			}
+		})
+	}
+
+	local, remote := hallOrdersWith(1, 0, HO_Confirmed), hallOrdersWith(1, 0, HO_Confirmed)
+	localVersions, remoteVersions := make([][2]uint64, testFloors), make([][2]uint64, testFloors)
+	localVersions[1][0], remoteVersions[1][0] = 3, 5
+	mergeHallOrders(local, localVersions, remote, remoteVersions)
+	if localVersions[1][0] != 5 {
+		t.Errorf("the version of the same state is %d, want the highest, 5", localVersions[1][0])
+	}
+	mergeHallOrders(local, localVersions, hallOrdersWith(1, 0, HO_Served)[:2], remoteVersions[:2])
+	if local[1][0] != HO_Confirmed {
+		t.Error("hall orders for another number of floors were merged")
+	}
+}
+
+//An order only moves on when every live peer has seen it, and a press on a served order waits for it to be none
+func TestAdvanceHallOrders(t *testing.T) {
+	live := []string{"a", "b", "c"}
+	local := hallOrdersWith(0, 0, HO_Unconfirmed)
+	views := map[string][][2]HallOrderState{"b": hallOrdersWith(0, 0, HO_Unconfirmed)}
+	pending := make([][2]bool, testFloors)
+	versions, versionViews := make([][2]uint64, testFloors), make(map[string][][2]uint64)
+
+	advanceHallOrders("a", local, versions, views, versionViews, live, pending)
+	if local[0][0] != HO_Unconfirmed {
+		t.Fatal("confirmed without a view from c")
+	}
+	views["c"] = hallOrdersWith(0, 0, HO_Confirmed) //c has already confirmed it
+	advanceHallOrders("a", local, versions, views, versionViews, live, pending)
+	if local[0][0] != HO_Confirmed {
+		t.Fatal("not confirmed when every live peer has seen it")
+	}
+
+	local[0][0] = HO_Served
+	pending[0][0] = true
+	views["b"], views["c"] = hallOrdersWith(0, 0, HO_Served), hallOrdersWith(0, 0, HO_Confirmed)
+	advanceHallOrders("a", local, versions, views, versionViews, live, pending)
+	if local[0][0] != HO_Served || !pending[0][0] {
+		t.Fatal("served order reset before c had seen it")
+	}
+	views["c"] = hallOrdersWith(0, 0, HO_Served)
+	advanceHallOrders("a", local, versions, views, versionViews, live, pending)
+	if local[0][0] != HO_Unconfirmed || pending[0][0] {
+		t.Fatal("the press on the served order was not taken when it was reset")
+	}
+
+	advanceHallOrders("a", local, versions, views, versionViews, []string{"a"}, pending)
+	if local[0][0] != HO_Confirmed {
+		t.Fatal("an order seen by the only live peer was not confirmed")
+	}
+
+	//c has reset the served order and taken a new press before its served view arrived here
+	local[0][0], versions[0][0] = HO_Served, 1<<16
+	views["b"], views["c"] = hallOrdersWith(0, 0, HO_Served), hallOrdersWith(0, 0, HO_Unconfirmed)
+	versionViews["b"], versionViews["c"] = make([][2]uint64, testFloors), make([][2]uint64, testFloors)
+	versionViews["b"][0][0], versionViews["c"][0][0] = 1<<16, 1<<16
+	advanceHallOrders("a", local, versions, views, versionViews, live, pending)
+	if local[0][0] != HO_Served {
+		t.Fatal("served order reset when c had not confirmed it")
+	}
+	versionViews["c"][0][0] = 2 << 16
+	advanceHallOrders("a", local, versions, views, versionViews, live, pending)
+	if local[0][0] != HO_None {
+		t.Fatal("served order not reset when c had a newer order")
+	}
+}
+
+//Type that holds simulated elevators, and what has really happened to every button: how many orders have been made
+//(pressed on a none button, or a pending press taken) and the last order served
+type hallNetwork struct {
+	t         *testing.T
+	elevators []*testElevator
+	episode   [][2]int
+	served    [][2]int
+	inFlight  map[string][]NetworkMessage //The messages on the way to every elevator
+}
+
+func newHallNetwork(t *testing.T, ids ...string) *hallNetwork {
+	n := &hallNetwork{t: t, episode: make([][2]int, testFloors), served: make([][2]int, testFloors), inFlight: make(map[string][]NetworkMessage)}
+	for _, id := range ids {
+		n.elevators = append(n.elevators, newTestElevator(id, ids...))
+	}
+	return n
+}
+
+//Runs change, which changes the hall orders through the elevator's own functions, and checks every button that
+//changed against what has really happened
+func (n *hallNetwork) change(e *testElevator, change func()) {
+	before, pendingBefore := copyHallOrders(e.states.HallOrders), append([][2]bool{}, e.pending...)
+	change()
+	for floor := range e.states.HallOrders {
+		for button := 0; button < 2; button++ {
+			if pendingBefore[floor][button] && !e.pending[floor][button] { //The press was taken as a new order
+				n.episode[floor][button]++
+			}
+			n.check(e, floor, button, before[floor][button], e.states.HallOrders[floor][button])
+		}
+	}
+}
+
+//Fails if an elevator lost a confirmed order that was not served, brought back a served one or went back in the cycle
+func (n *hallNetwork) check(e *testElevator, floor int, button int, from HallOrderState, to HallOrderState) {
+	if from == to {
+		return
+	}
+	served := n.served[floor][button] == n.episode[floor][button]
+	steps := (to - from + 4) % 4
+	switch {
+	case steps == 3:
+		n.t.Fatalf("%s went back from %d to %d at floor %d button %d", e.id, from, to, floor, button)
+	case outstanding(from) && !outstanding(to) && !served:
+		n.t.Fatalf("%s lost the order at floor %d button %d, %d to %d, before it was served", e.id, floor, button, from, to)
+	case !outstanding(from) && outstanding(to) && served:
+		n.t.Fatalf("%s brought back the served order at floor %d button %d, %d to %d", e.id, floor, button, from, to)
+	}
+}
+
+//Presses the button at the elevator with pressHallButton. A press on a none button is a new order
+func (n *hallNetwork) press(e *testElevator, floor int, button int) {
+	if e.states.HallOrders[floor][button] == HO_None {
+		n.episode[floor][button]++
+	}
+	n.change(e, func() { e.press(floor, elevio.ButtonType(button)) })
+}
+
+//Serves the order at the elevator with serveHallOrder, it is only served if it is confirmed
+func (n *hallNetwork) serve(e *testElevator, floor int, button int) {
+	if e.states.HallOrders[floor][button] == HO_Confirmed {
+		n.served[floor][button] = n.episode[floor][button]
+	}
+	n.change(e, func() { e.serve(floor, []string{"up", "down"}[button]) })
+}
+
+//Sends the elevator's next message to every other elevator. Every message is lost with the probability drop, and
+//received twice with the probability duplicate
+func (n *hallNetwork) broadcast(e *testElevator, r *rand.Rand, drop float64, duplicate float64) {
+	message := e.message()
+	for _, to := range n.elevators {
+		if to == e || r.Float64() < drop {
+			continue
+		}
+		n.inFlight[to.id] = append(n.inFlight[to.id], message)
+		if r.Float64() < duplicate {
+			n.inFlight[to.id] = append(n.inFlight[to.id], message)
+		}
+	}
+}
+
+//Delivers the messages on the way in a random order, the elevators take them the way UpdateFromNetwork does. Every
+//message is held back with the probability hold, so the ones after it overtake it
+func (n *hallNetwork) deliver(r *rand.Rand, hold float64) {
+	for _, to := range n.elevators {
+		messages := n.inFlight[to.id]
+		r.Shuffle(len(messages), func(i, j int) { messages[i], messages[j] = messages[j], messages[i] })
+		held := []NetworkMessage{}
+		for _, message := range messages {
+			if r.Float64() < hold {
+				held = append(held, message)
+				continue
+			}
+			n.change(to, func() { to.receive(message) })
+		}
+		n.inFlight[to.id] = held
+	}
+}
+
+//Presses and serves hall orders at random on elevators that drop, duplicate and reorder each other's messages. No
+//elevator may lose a confirmed order or bring back a served one, and when the network is good again every order that
+//was pressed is served and all elevators agree
+func TestHallOrdersLossyNetwork(t *testing.T) {
+	startTestDriver(t)
+	for seed := int64(1); seed <= 20; seed++ {
+		t.Run(fmt.Sprint("seed ", seed), func(t *testing.T) {
+			r := rand.New(rand.NewSource(seed))
+			n := newHallNetwork(t, "a", "b", "c")
+			for round := 0; round < 400; round++ {
+				e := n.elevators[r.Intn(len(n.elevators))]
+				floor, button := r.Intn(testFloors), r.Intn(2)
+				switch r.Intn(3) {
+				case 0:
+					n.press(e, floor, button)
+				case 1:
+					n.serve(e, floor, button)
+				}
+				for _, e := range n.elevators {
+					n.broadcast(e, r, 0.4, 0.2)
+				}
+				n.deliver(r, 0.3)
+			}
+
+			//The network is good again, and every confirmed order is served
+			for round := 0; round < 20; round++ {
+				for _, e := range n.elevators {
+					for floor := 0; floor < testFloors; floor++ {
+						for button := 0; button < 2; button++ {
+							n.serve(e, floor, button)
+						}
+					}
+					n.broadcast(e, r, 0, 0)
+				}
+				n.deliver(r, 0)
+			}
+			for _, e := range n.elevators {
+				for floor := range e.states.HallOrders {
+					for button := 0; button < 2; button++ {
+						if e.states.HallOrders[floor][button] != HO_None || e.pending[floor][button] {
+							t.Errorf("%s has state %d at floor %d button %d, want none", e.id, e.states.HallOrders[floor][button], floor, button)
+						}
+						if n.served[floor][button] != n.episode[floor][button] {
+							t.Errorf("order %d at floor %d button %d was never served", n.episode[floor][button], floor, button)
+						}
+					}
+				}
+			}
+		})
+	}
+}
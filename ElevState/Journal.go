@@ -0,0 +1,250 @@
+package ElevState
+
+/* The journal is an append-only log of the events that change the orders: buttons pressed, hall orders moving
+through their cycle, orders cleared and peers lost. Instead of writing a full snapshot of LocalAllStates on every event, each event is appended as one JSON
+line to the journal. Every CompactEvery entries the journal is compacted: a snapshot is written with the sequence
+number of the last entry it contains, and the journal is moved to a ".1" file, replacing the one before, and a new
+one is started. On start-up the entries newer than the snapshot are replayed on top of it, and new entries are
+appended to the same journal, so the entries from before a crash are still there to be dumped with the JournalDump
+tool for incident analysis. Only an entry the program stopped in the middle of writing is cut off.
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"../driver/elevio"
+)
+
+var JournalPath string       //Path of the journal file, if empty it is the backup path with ".journal" added
+var CompactEvery = 100       //Number of journal entries between each compaction into a snapshot
+var journalFile *os.File     //Journal file opened for appending, nil until InitElevState has replayed the journal
+var journalSeq uint64        //Sequence number of the last entry written to the journal
+var journalSinceSnapshot int //Number of entries written since the last compaction
+
+//Makes sure entries are appended one at the time and that compaction doesn't interleave with appends
+var journalMtx = sync.Mutex{}
+
+//Type of one event in the journal
+type JournalEntry struct {
+	Seq   uint64    `json:"seq"`
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"`
+	//"ButtonPressed"
+	//"OrderConfirmed" (hall orders only)
+	//"OrderCleared"
+	//"OrderReset" (hall orders only)
+	//"PeerLost"
+	Source  string            `json:"source"`           //ID of the elevator the event came from
+	PeerID  string            `json:"peerID,omitempty"` //ID of the elevator that owns the cab request, or that was lost
+	Floor   int               `json:"floor"`
+	Button  elevio.ButtonType `json:"button"`
+	State   HallOrderState    `json:"state"`             //The new state of a hall order
+	Version uint64            `json:"version,omitempty"` //The version of a hall order
+}
+
+//Returns the path of the journal file for this elevator
+func JournalFile() string {
+	if JournalPath != "" {
+		return JournalPath
+	}
+	return backupPath() + ".journal"
+}
+
+//Reads all entries in a journal. Entries that can't be read, like one the program stopped in the middle of
+//writing, are skipped, and the entries around them are returned together with an error describing the first one
+func ReadJournal(path string) ([]JournalEntry, error) {
+	entries := []JournalEntry{}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return entries, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	line := 0
+	var broken error
+	for scanner.Scan() {
+		line++
+		entry := JournalEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			if broken == nil {
+				broken = fmt.Errorf("broken entry on line %d: %v", line, err)
+			}
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return entries, err
+	}
+	return entries, broken
+}
+
+//Applies the journal entries that are newer than afterSeq to states, and returns the sequence number of the last one
+func replayJournal(states AllStates, entries []JournalEntry, afterSeq uint64) (AllStates, uint64) {
+	lastSeq := afterSeq
+	for _, entry := range entries {
+		if entry.Seq <= afterSeq { //already part of the snapshot
+			continue
+		}
+		lastSeq = entry.Seq
+		if entry.Floor < 0 || entry.Floor >= NFLOORS {
+			continue
+		}
+
+		switch entry.Event {
+		case "ButtonPressed", "OrderConfirmed", "OrderCleared", "OrderReset":
+			states = setRequest(states, entry)
+		case "PeerLost":
+			if entry.PeerID != ID {
+				delete(states.States, entry.PeerID)
+			}
+		}
+	}
+	return states, lastSeq
+}
+
+//Sets the state of a hall order, or the cab request of the given elevator if it is known
+func setRequest(states AllStates, entry JournalEntry) AllStates {
+	switch entry.Button {
+	case elevio.BT_HallUp, elevio.BT_HallDown:
+		if entry.Event == "ButtonPressed" && entry.State == HO_None { //Entry from before the hall orders, the press was lit at once
+			entry.State = HO_Confirmed
+		}
+		states.HallOrders[entry.Floor][entry.Button] = entry.State
+		if entry.Version > states.HallVersions[entry.Floor][entry.Button] {
+			states.HallVersions[entry.Floor][entry.Button] = entry.Version
+		}
+		setHallRequests(states)
+	case elevio.BT_Cab:
+		if state, exists := states.States[entry.PeerID]; exists {
+			state.CabRequests[entry.Floor] = entry.Event == "ButtonPressed"
+		}
+	}
+	return states
+}
+
+//Replays the journal on top of the snapshot, then opens the journal for new entries. The journal is kept, it is
+//only compacted when it has grown long enough
+func initJournal(states AllStates, snapshotSeq uint64) AllStates {
+	entries, err := ReadJournal(JournalFile())
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Println("Skipping journal entries:", err)
+	}
+	states, lastSeq := replayJournal(states, entries, snapshotSeq)
+	if lastSeq > snapshotSeq {
+		fmt.Println("Replayed", lastSeq-snapshotSeq, "journal entries")
+	}
+
+	journalMtx.Lock()
+	defer journalMtx.Unlock()
+	journalSeq = lastSeq
+
+	if err := cutBrokenEntry(JournalFile()); err != nil { //So new entries start on a line of their own
+		fmt.Println("Error repairing journal:", err)
+	}
+	file, err := os.OpenFile(JournalFile(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		fmt.Println("Error opening journal, falling back to snapshots:", err)
+		return states
+	}
+	journalFile = file
+	journalSinceSnapshot = int(lastSeq - snapshotSeq)
+	if journalSinceSnapshot >= CompactEvery {
+		compactJournal(states)
+	}
+	return states
+}
+
+//Cuts off an entry the program stopped in the middle of writing at the end of the journal. Every entry ends with a
+//newline, so it is everything after the last one
+func cutBrokenEntry(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	end := bytes.LastIndexByte(data, '\n') + 1
+	if end == len(data) {
+		return nil
+	}
+	fmt.Println("Cutting off a broken entry at the end of the journal")
+	return os.Truncate(path, int64(end))
+}
+
+//Appends events to the journal and publishes them on the event bus, and compacts the journal into a snapshot of
+//states when it has grown long enough. states must be the AllStates after the events have been applied
+func journalEvents(states AllStates, entries ...JournalEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	publishJournalEntries(entries)
+
+	journalMtx.Lock()
+	defer journalMtx.Unlock()
+
+	if journalFile == nil { //No journal, save everything in a snapshot as before
+		savingFile(states, ID)
+		return
+	}
+
+	now := time.Now()
+	var data []byte
+	for _, entry := range entries {
+		journalSeq++
+		entry.Seq = journalSeq
+		entry.Time = now
+		line, _ := json.Marshal(entry)
+		data = append(data, append(line, '\n')...)
+	}
+
+	_, err := journalFile.Write(data)
+	if err == nil {
+		err = journalFile.Sync()
+	}
+	if err != nil { //Make sure the events are not lost by saving a snapshot instead
+		fmt.Println("Error writing to journal:", err)
+		compactJournal(states)
+		return
+	}
+
+	journalSinceSnapshot += len(entries)
+	if journalSinceSnapshot >= CompactEvery {
+		compactJournal(states)
+	}
+}
+
+//Writes a snapshot containing every entry in the journal, then moves the journal to the ".1" file and starts a new
+//one. Must be called with journalMtx locked
+func compactJournal(states AllStates) {
+	err := writeSnapshot(backupPath(), snapshotPayload{AllStates: states, JournalSeq: journalSeq, CabBackups: CabBackupsCopy(), Policy: CurrentPolicy()})
+	if err != nil { //Keep the journal, it is still needed to recover the states
+		fmt.Println("Error saving states to file:", err)
+		return
+	}
+	journalSinceSnapshot = 0
+	if journalFile == nil {
+		return
+	}
+	journalFile.Close()
+	path := JournalFile()
+	if err := os.Rename(path, path+".1"); err != nil { //The entries are in the snapshot, so they are skipped if kept
+		fmt.Println("Error compacting journal:", err)
+	}
+	journalFile, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		fmt.Println("Error opening journal, falling back to snapshots:", err)
+		journalFile = nil
+		return
+	}
+	syncDir(filepath.Dir(path))
+}
@@ -0,0 +1,67 @@
+package ElevState
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"../driver/elevio"
+)
+
+//The journal is kept across restarts for incident analysis. A broken entry at the end is cut off, and compaction
+//moves the journal to the .1 file instead of emptying it
+func TestJournalKeptAcrossRestarts(t *testing.T) {
+	NFLOORS, ID = testFloors, "a"
+	BackupPath = filepath.Join(t.TempDir(), "states.txt")
+	compactEvery := CompactEvery
+	CompactEvery = 3
+	defer func() { BackupPath, CompactEvery = "", compactEvery }()
+
+	//Starts the elevator again the way InitElevState does, from the snapshot and the journal
+	restart := func() AllStates {
+		if journalFile != nil {
+			journalFile.Close()
+		}
+		journalFile, journalSeq, journalSinceSnapshot = nil, 0, 0
+		states := newTestElevator("a", "a").states
+		snapshotSeq := uint64(0)
+		if recovered, ok := recoverSnapshot(); ok {
+			states, snapshotSeq = recovered.AllStates, recovered.JournalSeq
+		}
+		return initJournal(states, snapshotSeq)
+	}
+	press := func(states AllStates, floor int) {
+		states.States["a"].CabRequests[floor] = true
+		journalEvents(states, JournalEntry{Event: "ButtonPressed", Source: "a", PeerID: "a", Floor: floor, Button: elevio.BT_Cab})
+	}
+
+	states := restart()
+	press(states, 1)
+	press(states, 2)
+	states = restart()
+	if entries, err := ReadJournal(JournalFile()); err != nil || len(entries) != 2 {
+		t.Fatalf("the journal has %d entries (%v) after the restart, want 2", len(entries), err)
+	}
+	if !states.States["a"].CabRequests[1] || !states.States["a"].CabRequests[2] {
+		t.Errorf("cab requests %v were not replayed", states.States["a"].CabRequests)
+	}
+
+	//The elevator stopped in the middle of writing an entry
+	file, _ := os.OpenFile(JournalFile(), os.O_WRONLY|os.O_APPEND, 0644)
+	file.WriteString(`{"seq":3,"ev`)
+	file.Close()
+	states = restart()
+	press(states, 3) //The third entry since the snapshot, so the journal is compacted
+	if entries, err := ReadJournal(JournalFile() + ".1"); err != nil || len(entries) != 3 || entries[2].Floor != 3 {
+		t.Errorf("the compacted journal has entries %v (%v), want the three presses", entries, err)
+	}
+	if entries, err := ReadJournal(JournalFile()); err != nil || len(entries) != 0 {
+		t.Errorf("the new journal has entries %v (%v), want none", entries, err)
+	}
+	states = restart()
+	if !states.States["a"].CabRequests[3] {
+		t.Errorf("cab requests %v were not recovered from the snapshot", states.States["a"].CabRequests)
+	}
+	journalFile.Close()
+	journalFile = nil
+}
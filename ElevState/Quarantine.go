@@ -0,0 +1,106 @@
+package ElevState
+
+/* Quarantine checks every message from the network before it is used. A message from a peer that runs with another
+number of floors, or that has slices of the wrong length, would make this elevator index out of range, so the
+message is dropped and the peer is quarantined: it is removed from LocalAllStates and doesn't have to see hall orders
+before they move on. The peer is let back in as soon as it sends a valid message, and is then reconciled like a new peer.
+*/
+
+import (
+	"fmt"
+	"sync"
+)
+
+var quarantined = make(map[string]string) //Quarantined peers, with the reason they were quarantined
+
+//Makes sure the quarantined peers are not read and written at the same time
+var quarantineMtx = sync.Mutex{}
+
+//Checks that a message has the same number of floors as this elevator and slices of the right lengths
+func validateMessage(message NetworkMessage) error {
+	if message.Floors != 0 && message.Floors != NFLOORS { //Elevators that don't send the number of floors only get the other checks
+		return fmt.Errorf("runs with %d floors, this elevator has %d", message.Floors, NFLOORS)
+	}
+	if len(message.RemoteState.CabRequests) != NFLOORS {
+		return fmt.Errorf("sent %d cab requests, expected %d", len(message.RemoteState.CabRequests), NFLOORS)
+	}
+	if message.RemoteState.Floor < 0 || message.RemoteState.Floor >= NFLOORS {
+		return fmt.Errorf("is at floor %d, outside of the %d floors", message.RemoteState.Floor, NFLOORS)
+	}
+	for _, event := range message.Events {
+		if event.Floor < 0 || event.Floor >= NFLOORS {
+			return fmt.Errorf("sent a %s at floor %d, outside of the %d floors", event.MessageType, event.Floor, NFLOORS)
+		}
+	}
+	if len(message.HallRequests) != NFLOORS || len(message.HallOrders) != NFLOORS || len(message.HallVersions) != NFLOORS {
+		return fmt.Errorf("sent %d hall requests, %d hall orders and %d versions, expected %d",
+			len(message.HallRequests), len(message.HallOrders), len(message.HallVersions), NFLOORS)
+	}
+	for floor := range message.HallOrders {
+		for button := 0; button < 2; button++ {
+			if message.HallOrders[floor][button] < HO_None || message.HallOrders[floor][button] > HO_Served {
+				return fmt.Errorf("sent unknown hall order state %d", message.HallOrders[floor][button])
+			}
+		}
+	}
+	return nil
+}
+
+//Returns true if the message is valid. If it is not, the sender is quarantined. If it is and the sender was
+//quarantined, it is let back in and marked to be reconciled
+func admitMessage(message NetworkMessage) bool {
+	err := validateMessage(message)
+
+	quarantineMtx.Lock()
+	reason, wasQuarantined := quarantined[message.ID]
+	if err != nil {
+		quarantined[message.ID] = err.Error()
+	} else {
+		delete(quarantined, message.ID)
+	}
+	quarantineMtx.Unlock()
+
+	switch {
+	case err != nil && (!wasQuarantined || reason != err.Error()): //Only print when something changes
+		fmt.Println("Quarantined peer", message.ID+":", err)
+		removeQuarantinedPeer(message.ID)
+		Publish(Event{Type: EV_Fault, PeerID: message.ID, Detail: "quarantined: " + err.Error()})
+	case err == nil && wasQuarantined:
+		fmt.Println("Peer", message.ID, "is no longer quarantined")
+		markForReconcile([]string{message.ID})
+		Publish(Event{Type: EV_FaultCleared, PeerID: message.ID, Detail: "quarantine lifted"})
+	}
+	return err == nil
+}
+
+//Removes a quarantined peer from LocalAllStates and from the peers that have to see hall orders
+func removeQuarantinedPeer(id string) {
+	Mtx.Lock()
+	delete(LocalAllStates.States, id)
+	Mtx.Unlock()
+
+	hallMtx.Lock()
+	delete(hallViews, id)
+	delete(hallVersionViews, id)
+	hallMtx.Unlock()
+}
+
+//Returns true if the peer is quarantined
+func isQuarantined(id string) bool {
+	quarantineMtx.Lock()
+	defer quarantineMtx.Unlock()
+	_, exists := quarantined[id]
+	return exists
+}
+
+//Returns the quarantined peers, with the reason they were quarantined
+func QuarantinedPeers() map[string]string {
+	quarantineMtx.Lock()
+	defer quarantineMtx.Unlock()
+
+	peers := make(map[string]string)
+	for id, reason := range quarantined {
+		peers[id] = reason
+	}
+	return peers
+}
@@ -0,0 +1,105 @@
+package ElevState
+
+/* Reconcile merges the state of a peer that has just joined the network, either because it was started or because
+the network was split and has healed. While apart, both sides may have registered and served hall orders, so the
+usual rule of only taking the next state in the cycle is not enough: a peer that was apart may be several steps
+ahead or behind. When the peers module reports a new peer, the next message from it is reconciled instead:
+its elevator state is added to LocalAllStates, and every hall order is merged by its version. Both sides of the
+merge get the same result, since the rules below don't depend on which side is local:
+ - Same version, same order: the state that is furthest along wins, so an order served on one side is served.
+ - Different versions, one side has an unserved order: it is kept, since the other side has never seen it. This is
+   so even if the other side has a newer version that is none, since the versions don't tell if that side has seen
+   the order: a stale confirmed order from before the split wins over a newer none, and is served again, not lost.
+ - Different versions, otherwise: the highest version wins.
+*/
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+var pendingReconcile = make(map[string]bool) //Peers that have joined, whose next message should be reconciled
+
+//Makes sure the pending peers are not read and written at the same time
+var reconcileMtx = sync.Mutex{}
+
+//Returns a version that is higher than all the versions, of every button, for an order pressed at the elevator id.
+//The versions are a clock shared by the buttons: a press made after another press has been seen gets a higher
+//version, so the versions give the order the requests were made in (see RoundRobin in DistributeOrders). The lowest
+//16 bits tell which elevator made the version, so orders pressed on different sides of a split network don't get the
+//same version
+func newHallOrderVersion(versions [][2]uint64, id string) uint64 {
+	highest := uint64(0)
+	for floor := range versions {
+		for button := 0; button < 2; button++ {
+			if versions[floor][button] > highest {
+				highest = versions[floor][button]
+			}
+		}
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(id))
+	return ((highest>>16)+1)<<16 | uint64(hash.Sum32()&0xffff)
+}
+
+//Marks peers that have joined the network, so their next message is reconciled
+func markForReconcile(ids []string) {
+	reconcileMtx.Lock()
+	defer reconcileMtx.Unlock()
+	for _, id := range ids {
+		if id != "" && id != ID {
+			pendingReconcile[id] = true
+		}
+	}
+}
+
+//Returns true if the message from the peer id should be reconciled, and unmarks it
+func takeReconcile(id string) bool {
+	reconcileMtx.Lock()
+	defer reconcileMtx.Unlock()
+	reconcile := pendingReconcile[id]
+	delete(pendingReconcile, id)
+	return reconcile
+}
+
+//Returns how far along the cycle of one order a state is. None comes last, since an order that has a version and
+//is none has been served and cleared by every peer
+func hallOrderRank(state HallOrderState) int {
+	if state == HO_None {
+		return 4
+	}
+	return int(state)
+}
+
+//Returns true if the state is an order that is not served yet
+func outstanding(state HallOrderState) bool {
+	return state == HO_Unconfirmed || state == HO_Confirmed
+}
+
+//Merges the hall orders of a peer that has joined into the local ones, by version
+func reconcileHallOrders(local [][2]HallOrderState, localVersions [][2]uint64, remote [][2]HallOrderState, remoteVersions [][2]uint64) {
+	if len(remote) != len(local) || len(remoteVersions) != len(local) {
+		return
+	}
+	for floor := range local {
+		for button := 0; button < 2; button++ {
+			localState, remoteState := local[floor][button], remote[floor][button]
+			localVersion, remoteVersion := localVersions[floor][button], remoteVersions[floor][button]
+
+			takeRemote := false
+			switch {
+			case localVersion == remoteVersion:
+				takeRemote = hallOrderRank(remoteState) > hallOrderRank(localState)
+			case outstanding(localState) != outstanding(remoteState):
+				takeRemote = outstanding(remoteState)
+			default:
+				takeRemote = remoteVersion > localVersion
+			}
+
+			if takeRemote {
+				local[floor][button] = remoteState
+				localVersions[floor][button] = remoteVersion
+			}
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package ElevState
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"../Network/network/peers"
+	"../driver/elevio"
+)
+
+//Type that holds the package state of one elevator, so several elevators can be run in turn in one test
+type testElevator struct {
+	id           string
+	states       AllStates
+	views        map[string][][2]HallOrderState
+	versionViews map[string][][2]uint64
+	live         []string
+	pending      [][2]bool
+	accepted     map[string]messagePosition //The newest message taken from every peer, see Sequence.go
+	seq          uint64
+}
+
+func newTestElevator(id string, ids ...string) *testElevator {
+	e := &testElevator{id: id, states: AllStates{HallRequests: make([][2]bool, testFloors), HallOrders: make([][2]HallOrderState, testFloors),
+		HallVersions: make([][2]uint64, testFloors), States: make(map[string]SingleStates)},
+		views: make(map[string][][2]HallOrderState), versionViews: make(map[string][][2]uint64), live: ids, pending: make([][2]bool, testFloors),
+		accepted: make(map[string]messagePosition)}
+	for _, peer := range ids {
+		e.states.States[peer] = SingleStates{Behavior: "idle", Direction: "stop", CabRequests: make([]bool, testFloors)}
+	}
+	return e
+}
+
+//Runs f as the elevator, with its state in the package variables
+func (e *testElevator) use(f func()) {
+	ID, LocalAllStates, hallViews, hallVersionViews, livePeers, pendingHallPresses = e.id, e.states, e.views, e.versionViews, e.live, e.pending
+	lastAccepted = e.accepted
+	f()
+	e.states, e.views, e.versionViews, e.live, e.pending = LocalAllStates, hallViews, hallVersionViews, livePeers, pendingHallPresses
+}
+
+//Returns the next message the elevator sends to its peers, numbered the way the Network module does
+func (e *testElevator) message() NetworkMessage {
+	var message NetworkMessage
+	e.seq++
+	e.use(func() {
+		message = FreshNetworkMessage(NetworkMessage{ID: ID, MessageType: "StateUpdate", Floors: NFLOORS, RemoteState: LocalAllStates.States[ID],
+			Epoch: 1, Seq: e.seq})
+	})
+	return message
+}
+
+//Takes a message from a peer the way UpdateFromNetwork does, messages older than one already taken are dropped
+func (e *testElevator) receive(message NetworkMessage) {
+	e.use(func() {
+		if !admitMessage(message) || !acceptMessage(message) {
+			return
+		}
+		reconcile := takeReconcile(message.ID)
+		mergeHallOrdersFromNetwork(LocalAllStates, message, reconcile)
+		LocalAllStates = updateAllStatesNetwork(message, LocalAllStates, reconcile)
+	})
+}
+
+func (e *testElevator) press(floor int, button elevio.ButtonType) {
+	e.use(func() { pressHallButton(LocalAllStates, floor, button) })
+}
+
+func (e *testElevator) serve(floor int, direction string) {
+	e.use(func() { serveHallOrder(LocalAllStates, floor, direction) })
+}
+
+//Sends the messages of the elevators to each other until the hall orders have settled
+func exchange(elevators ...*testElevator) {
+	for round := 0; round < 4; round++ {
+		for _, from := range elevators {
+			message := from.message()
+			for _, to := range elevators {
+				if to != from {
+					to.receive(message)
+				}
+			}
+		}
+	}
+}
+
+//Connects the driver to a listener that discards the commands, so the lights can be set, and keeps the backups in a
+//temporary directory
+func startTestDriver(t *testing.T) {
+	NFLOORS = testFloors
+	BackupPath = filepath.Join(t.TempDir(), "states.txt")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(ioutil.Discard, conn)
+		}
+	}()
+	elevio.Init(listener.Addr().String(), testFloors)
+}
+
+//Sends the peer update to UpdatePeers, and returns when it has been handled
+func sendPeers(updates chan<- peers.PeerUpdate, update peers.PeerUpdate) {
+	updates <- update
+	updates <- peers.PeerUpdate{Peers: update.Peers} //Only taken when the first one is handled
+}
+
+//A press gets a version above every button's, so the versions give the order the requests were made in
+func TestHallOrderVersionsOrderPresses(t *testing.T) {
+	versions := make([][2]uint64, testFloors)
+	versions[3][elevio.BT_HallDown] = newHallOrderVersion(versions, "b")
+	versions[0][elevio.BT_HallUp] = newHallOrderVersion(versions, "a")
+	versions[2][elevio.BT_HallUp] = newHallOrderVersion(versions, "b")
+	if !(versions[3][elevio.BT_HallDown] < versions[0][elevio.BT_HallUp] && versions[0][elevio.BT_HallUp] < versions[2][elevio.BT_HallUp]) {
+		t.Errorf("the versions %v are not in the order the buttons were pressed", versions)
+	}
+	if versions[0][elevio.BT_HallUp]&0xffff == versions[2][elevio.BT_HallUp]&0xffff {
+		t.Error("presses on different elevators have the same lowest bits")
+	}
+}
+
+//The network is split between a and b, and both press and serve hall calls and take cab calls while apart. When it
+//heals, a reconciles through UpdatePeers and UpdateFromNetwork, and b takes a's message the same way, so both must end
+//up with the same hall orders and with each other's cab requests
+func TestPartitionHeal(t *testing.T) {
+	startTestDriver(t)
+	a, b := newTestElevator("a", "a", "b"), newTestElevator("b", "a", "b")
+
+	//Orders both have confirmed before the split
+	a.press(0, elevio.BT_HallUp)
+	a.press(1, elevio.BT_HallDown)
+	b.press(3, elevio.BT_HallDown)
+	exchange(a, b)
+	for _, e := range []*testElevator{a, b} {
+		if !e.states.HallRequests[0][elevio.BT_HallUp] || !e.states.HallRequests[1][elevio.BT_HallDown] || !e.states.HallRequests[3][elevio.BT_HallDown] {
+			t.Fatalf("%s has hall requests %v before the split", e.id, e.states.HallRequests)
+		}
+	}
+	before := a.states.HallVersions[3][elevio.BT_HallDown]
+
+	peerUpdates := make(chan peers.PeerUpdate)
+	fromNetwork := make(chan NetworkMessage)
+	updated := make(chan AllStates, 100)
+	go UpdatePeers(peerUpdates, updated)
+	go UpdateFromNetwork(fromNetwork, updated)
+
+	//The split, a finds out through UpdatePeers
+	a.use(func() { sendPeers(peerUpdates, peers.PeerUpdate{Peers: []string{"a"}, Lost: []string{"b"}}) })
+	b.use(func() {
+		updateLivePeers(LocalAllStates, []string{"b"}, []string{"a"})
+		delete(LocalAllStates.States, "a")
+	})
+	if _, exists := a.states.States["b"]; exists {
+		t.Fatal("b is still in a's states after the split")
+	}
+
+	//a serves the call at floor 0, takes a new call at floor 2 and a cab call
+	a.serve(0, "up")
+	a.press(2, elevio.BT_HallUp)
+	a.states.States["a"].CabRequests[3] = true
+
+	//b serves the call at floor 1 and gets a new one there. It serves the call at floor 3, gets a new one and
+	//serves that too, so it has a newer version of floor 3 that is none. It also takes a cab call and moves
+	b.serve(1, "down")
+	b.press(1, elevio.BT_HallDown)
+	b.serve(3, "down")
+	b.press(3, elevio.BT_HallDown)
+	b.serve(3, "down")
+	b.states.States["b"] = SingleStates{Behavior: "moving", Floor: 2, Direction: "down", CabRequests: []bool{true, false, false, false}}
+	if b.states.HallOrders[3][elevio.BT_HallDown] != HO_None || b.states.HallVersions[3][elevio.BT_HallDown] <= before {
+		t.Fatalf("b has floor 3 down %d with version %x, want none with a version above %x",
+			b.states.HallOrders[3][elevio.BT_HallDown], b.states.HallVersions[3][elevio.BT_HallDown], before)
+	}
+
+	//The network heals. a reconciles b's next message through UpdatePeers and UpdateFromNetwork, and b reconciles a's
+	fromA, fromB := a.message(), b.message()
+	a.use(func() {
+		sendPeers(peerUpdates, peers.PeerUpdate{Peers: []string{"a", "b"}, New: "b"})
+		for len(updated) > 0 {
+			<-updated
+		}
+		fromNetwork <- fromB
+		<-updated
+	})
+	b.use(func() {
+		updateLivePeers(LocalAllStates, []string{"a", "b"}, nil)
+		markForReconcile([]string{"a"})
+	})
+	b.receive(fromA)
+
+	want := []struct {
+		floor  int
+		button elevio.ButtonType
+		state  HallOrderState
+		why    string
+	}{
+		{0, elevio.BT_HallUp, HO_None, "served by a, same version, the state furthest along wins"},
+		{1, elevio.BT_HallDown, HO_Confirmed, "new call on b, both outstanding, the highest version wins"},
+		{2, elevio.BT_HallUp, HO_Confirmed, "new call on a that b has never seen"},
+		//a's stale confirmed order from before the split wins over b's newer none: the versions don't tell if b has
+		//seen a's order, so the outstanding one is kept. The call is served again, but a call is never lost
+		{3, elevio.BT_HallDown, HO_Confirmed, "stale confirmed (v1) against a newer none (v2), the confirmed one wins"},
+	}
+	for _, e := range []*testElevator{a, b} {
+		for _, w := range want {
+			if got := e.states.HallOrders[w.floor][w.button]; got != w.state {
+				t.Errorf("%s has state %d at floor %d button %d, want %d: %s", e.id, got, w.floor, w.button, w.state, w.why)
+			}
+			if got := e.states.HallRequests[w.floor][w.button]; got != (w.state == HO_Confirmed) {
+				t.Errorf("%s has hall request %v at floor %d button %d: %s", e.id, got, w.floor, w.button, w.why)
+			}
+		}
+	}
+	if a.states.HallVersions[3][elevio.BT_HallDown] != before || b.states.HallVersions[3][elevio.BT_HallDown] != before {
+		t.Errorf("floor 3 down has versions %x on a and %x on b, want the version from before the split, %x",
+			a.states.HallVersions[3][elevio.BT_HallDown], b.states.HallVersions[3][elevio.BT_HallDown], before)
+	}
+	for floor := range a.states.HallVersions {
+		if a.states.HallVersions[floor] != b.states.HallVersions[floor] {
+			t.Errorf("floor %d has versions %v on a and %v on b", floor, a.states.HallVersions[floor], b.states.HallVersions[floor])
+		}
+	}
+
+	//Both have their own cab requests and the other's, and the other's state from after the split
+	for _, e := range []*testElevator{a, b} {
+		if cab := e.states.States["a"].CabRequests; len(cab) != testFloors || !cab[3] {
+			t.Errorf("%s has cab requests %v for a, want floor 3", e.id, cab)
+		}
+		if state := e.states.States["b"]; len(state.CabRequests) != testFloors || !state.CabRequests[0] || state.Floor != 2 {
+			t.Errorf("%s has state %+v for b, want floor 2 with a cab request at floor 0", e.id, state)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package ElevState
+
+/* Sequence keeps track of the newest NetworkMessage received from every peer. Every message carries the time its
+sender was started (the boot epoch) and a sequence number that the sender's Network module increases for every
+message it sends. A message that is not newer than the last one accepted from the same sender is stale: it was
+delayed or reordered on the way, and taking it would roll the sender's state backwards, so it is dropped and counted.
+*/
+
+import (
+	"sync"
+)
+
+//Type that holds the position of a message in the stream of messages from its sender
+type messagePosition struct {
+	Epoch int64
+	Seq   uint64
+}
+
+var lastAccepted = make(map[string]messagePosition) //The newest message accepted from every peer
+var staleDrops = make(map[string]uint64)            //The number of stale messages dropped from every peer
+
+//Makes sure the message positions and counters are not read and written at the same time
+var sequenceMtx = sync.Mutex{}
+
+//Returns true if the message is newer than the last one accepted from its sender, and remembers it if it is.
+//Messages without an epoch are from elevators that don't number their messages, and are always accepted
+func acceptMessage(message NetworkMessage) bool {
+	if message.Epoch == 0 {
+		return true
+	}
+
+	sequenceMtx.Lock()
+	defer sequenceMtx.Unlock()
+
+	last, exists := lastAccepted[message.ID]
+	if exists && (message.Epoch < last.Epoch || (message.Epoch == last.Epoch && message.Seq <= last.Seq)) {
+		staleDrops[message.ID]++
+		return false
+	}
+	lastAccepted[message.ID] = messagePosition{Epoch: message.Epoch, Seq: message.Seq}
+	return true
+}
+
+//Forgets the position of a lost peer, so it is accepted again even if its clock was set back before it restarted
+func forgetSender(id string) {
+	sequenceMtx.Lock()
+	defer sequenceMtx.Unlock()
+	delete(lastAccepted, id)
+}
+
+//Returns the number of stale messages dropped from every peer
+func StaleDropCounts() map[string]uint64 {
+	sequenceMtx.Lock()
+	defer sequenceMtx.Unlock()
+
+	counts := make(map[string]uint64)
+	for id, count := range staleDrops {
+		counts[id] = count
+	}
+	return counts
+}
+
+//Returns the total number of stale messages dropped
+func StaleDropTotal() uint64 {
+	total := uint64(0)
+	for _, count := range StaleDropCounts() {
+		total += count
+	}
+	return total
+}
@@ -0,0 +1,25 @@
+package FSM
+
+/* The decisions the FSM makes, exported so the same single elevator logic can run without the driver and the timers,
+see Simulated.go. NFLOORS must be set before they are used.
+*/
+
+import (
+	"../DistributeOrders"
+	"../driver/elevio"
+)
+
+//Returns true if the elevator should stop at the floor. doorOpen is true if it already has its door open there
+func ShouldStop(orders DistributeOrders.OrderUpdate, floor int, doorOpen bool) bool {
+	return shouldStop(orders, floor, doorOpen)
+}
+
+//Returns the direction the elevator should go from the floor
+func ChooseDirection(orders DistributeOrders.OrderUpdate, floor int) elevio.MotorDirection {
+	return chooseDirection(orders, floor)
+}
+
+//Returns the direction of the hall order to clear when stopping at the floor: up, down or noHall
+func ClearDirection(orders DistributeOrders.OrderUpdate, floor int) string {
+	return clearDirection(orders, floor)
+}
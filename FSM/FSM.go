@@ -17,20 +17,26 @@ import (
 var ID string   // number of floors
 var NFLOORS int //Peer ID (IP address)
 
+var DoorTime = 3 * time.Second //How long the door is open at a floor, sent to the others in the state
+
 func FSM(CalculatedOrders <-chan DistributeOrders.OrderUpdate, FSMEventMsg chan<- ElevState.EventMessage) {
 
 	var lastUpdateMessage DistributeOrders.OrderUpdate //Message from DistributeOrders: This elevator's calculated orders and its state
 	var updateMessage ElevState.EventMessage           //Message to ElevateState: What event happened(floor reached, door open, etc.) and what action was performed(motor stopping, an order was cleared, etc)
 	var prevFloor = 0                                  //Previous floor of the elevator, always initialized to 0 in this implementation
 
-	doorOpenChooseDirection := time.NewTimer(3 * time.Second) //Door is defined to be open for 3 seconds at a time
+	doorOpenChooseDirection := time.NewTimer(DoorTime) //Door is defined to be open for DoorTime at a time
 	doorOpenChooseDirection.Stop()                            //stops the timer from sending
 	motorStopsWorking := time.NewTimer(5 * time.Second)
 	motorStopsWorking.Stop()
 
 	FloorSensor := make(chan int)
+	Obstruction := make(chan bool)
+	obstructed := false //The door can't close while it is obstructed
+	obstructedFor := 0  //Number of times in a row the door couldn't close
 
 	go elevio.PollFloorSensor(FloorSensor)
+	go elevio.PollObstructionSwitch(Obstruction)
 
 	initializeFSM(FSMEventMsg, updateMessage, FloorSensor) /*initializing the elevator by driving it to 0th floor
 	and sending an EventMsg to ElevState in order to make it start processing existing/incoming orders*/
@@ -57,19 +63,11 @@ func FSM(CalculatedOrders <-chan DistributeOrders.OrderUpdate, FSMEventMsg chan<
 				elevio.SetMotorDirection(elevio.MD_Stop)
 				elevio.SetDoorOpenLamp(true)
 
-				doorOpenChooseDirection.Reset(3 * time.Second) //Starts the "doorOpenChooseDirection.C" case after 3 seconds
+				doorOpenChooseDirection.Reset(DoorTime) //Starts the "doorOpenChooseDirection.C" case after DoorTime
 
 				//Determine which order should be cleared and send direction to update
-				if lastUpdateMessage.DistributedOrders[newFloor][0] && (lastUpdateMessage.State.Direction == "up" || !evaluateBelowOrders(lastUpdateMessage, newFloor)) {
-					updateMessage.ClearOrderDirection = "up"
-					updateMessage.Direction = "up"
-				} else if lastUpdateMessage.DistributedOrders[newFloor][1] && (lastUpdateMessage.State.Direction == "down" || !evaluateAboveOrders(lastUpdateMessage, newFloor)) {
-					updateMessage.ClearOrderDirection = "down"
-					updateMessage.Direction = "down"
-				} else {
-					updateMessage.ClearOrderDirection = "noHall"
-					updateMessage.Direction = "stop"
-				}
+				updateMessage.ClearOrderDirection = clearDirection(lastUpdateMessage, newFloor)
+				updateMessage.Direction = map[string]string{"up": "up", "down": "down", "noHall": "stop"}[updateMessage.ClearOrderDirection]
 
 				updateMessage.EventType = "ClearOrder"
 				updateMessage.Behavior = "doorOpen"
@@ -117,7 +115,7 @@ func FSM(CalculatedOrders <-chan DistributeOrders.OrderUpdate, FSMEventMsg chan<
 					if localElev.DistributedOrders[currentFloor][0] || localElev.DistributedOrders[currentFloor][1] || localElev.State.CabRequests[currentFloor] {
 						//If so it resets the door timer and turn on lights
 						elevio.SetDoorOpenLamp(true)
-						doorOpenChooseDirection.Reset(3 * time.Second)
+						doorOpenChooseDirection.Reset(DoorTime)
 
 						//Clear order if there is one at this floor
 						if lastUpdateMessage.DistributedOrders[currentFloor][0] {
@@ -141,16 +139,10 @@ func FSM(CalculatedOrders <-chan DistributeOrders.OrderUpdate, FSMEventMsg chan<
 				if shouldStop(localElev, localElev.State.Floor, openDoorCase) {
 					openAtFloor := localElev.State.Floor
 					elevio.SetDoorOpenLamp(true)
-					doorOpenChooseDirection.Reset(3 * time.Second)
+					doorOpenChooseDirection.Reset(DoorTime)
 
 					//Clear order if there is one at this floor
-					if localElev.DistributedOrders[openAtFloor][0] && (localElev.State.Direction == "up" || !evaluateBelowOrders(localElev, openAtFloor)) {
-						updateMessage.ClearOrderDirection = "up"
-					} else if localElev.DistributedOrders[openAtFloor][1] && (localElev.State.Direction == "down" || !evaluateAboveOrders(localElev, openAtFloor)) {
-						updateMessage.ClearOrderDirection = "down"
-					} else {
-						updateMessage.ClearOrderDirection = "noHall"
-					}
+					updateMessage.ClearOrderDirection = clearDirection(localElev, openAtFloor)
 
 					updateMessage.EventType = "ClearOrder"
 					updateMessage.Behavior = "doorOpen"
@@ -163,7 +155,31 @@ func FSM(CalculatedOrders <-chan DistributeOrders.OrderUpdate, FSMEventMsg chan<
 
 			}
 
+		case obstructed = <-Obstruction: //The door is checked for obstruction when it should close
+
 		case <-doorOpenChooseDirection.C: //door closes and new direction is evaluated,it is started when the 3 second timer runs out
+			if obstructed { //Keeps the door open, and after 3 tries tells ElevState the elevator is unavailable
+				obstructedFor++
+				doorOpenChooseDirection.Reset(DoorTime)
+				if obstructedFor == 3 {
+					updateMessage.EventType = "DoorProblems"
+					updateMessage.Behavior = "doorOpen"
+					updateMessage.Direction = lastUpdateMessage.State.Direction
+					updateMessage.Floor = lastUpdateMessage.State.Floor
+					updateMessage.ClearOrderDirection = "noHall"
+					FSMEventMsg <- updateMessage
+				}
+				break
+			}
+			if obstructedFor >= 3 { //The door works again and the elevator can take hall requests
+				updateMessage.EventType = "DoorWorksAgain"
+				updateMessage.Behavior = "doorOpen"
+				updateMessage.Direction = lastUpdateMessage.State.Direction
+				updateMessage.Floor = lastUpdateMessage.State.Floor
+				updateMessage.ClearOrderDirection = "noHall"
+				FSMEventMsg <- updateMessage
+			}
+			obstructedFor = 0
 			elevio.SetDoorOpenLamp(false)
 			newDirection := chooseDirection(lastUpdateMessage, lastUpdateMessage.State.Floor) //Choosing direction based on last message from DistributeOrders
 
@@ -304,6 +320,18 @@ func evaluateBelowOrders(currentOrders DistributeOrders.OrderUpdate, currentFloo
 	}
 	return false
 }
+
+//Returns the direction of the hall order to clear when stopping at a floor: the one in the direction the elevator
+//is going, or the other one if there are no orders further in that direction, or noHall if there is none
+func clearDirection(currentOrders DistributeOrders.OrderUpdate, floor int) string {
+	if currentOrders.DistributedOrders[floor][0] && (currentOrders.State.Direction == "up" || !evaluateBelowOrders(currentOrders, floor)) {
+		return "up"
+	} else if currentOrders.DistributedOrders[floor][1] && (currentOrders.State.Direction == "down" || !evaluateAboveOrders(currentOrders, floor)) {
+		return "down"
+	}
+	return "noHall"
+}
+
 //Evaluate orders below and above, choose optimal direction
 func chooseDirection(currentOrders DistributeOrders.OrderUpdate, floor int) elevio.MotorDirection {
 
@@ -0,0 +1,106 @@
+package FSM
+
+/* SimulatedCar runs the decisions of the FSM on a car without the driver and the timers, in steps of simulated time,
+and returns the EventMessages the FSM would send to ElevState. Tools/DispatchBench runs it in simulated time, and
+Network/LossyNetwork_test.go in real time with the real ElevState and DistributeOrders. NFLOORS must be set before it
+is used.
+*/
+
+import (
+	"time"
+
+	"../DistributeOrders"
+	"../ElevState"
+	"../driver/elevio"
+)
+
+//Type of a simulated car, with the time left until it gets to the next floor or closes its door
+type SimulatedCar struct {
+	State ElevState.SingleStates
+	Timer time.Duration
+}
+
+//Does what the FSM does when it gets new orders: an idle car starts moving or opens its door for an order at its
+//floor, and a car with its door open keeps it open for a new order at its floor. Returns the event the FSM sends,
+//and false if there is none
+func (e *SimulatedCar) OnOrders(orders DistributeOrders.OrderUpdate) (ElevState.EventMessage, bool) {
+	floor := e.State.Floor
+	switch e.State.Behavior {
+	case "idle":
+		switch ChooseDirection(orders, floor) {
+		case elevio.MD_Up:
+			return e.startDriving("up"), true
+		case elevio.MD_Down:
+			return e.startDriving("down"), true
+		case elevio.MD_Stop:
+			if orders.DistributedOrders[floor][0] || orders.DistributedOrders[floor][1] || orders.State.CabRequests[floor] {
+				clear := "noHall"
+				if orders.DistributedOrders[floor][0] {
+					clear = "up"
+				} else if orders.DistributedOrders[floor][1] {
+					clear = "down"
+				}
+				e.State.Behavior, e.State.Direction, e.Timer = "doorOpen", "stop", e.State.DoorTime
+				return e.event("ClearOrder", clear), true
+			}
+		}
+	case "doorOpen":
+		if ShouldStop(orders, floor, true) {
+			e.Timer = e.State.DoorTime
+			return e.event("ClearOrder", ClearDirection(orders, floor)), true
+		}
+	}
+	return ElevState.EventMessage{}, false
+}
+
+//Moves the car on by step: a moving car gets to the next floor and stops there if it should, and a car with its door
+//open closes it and goes on or becomes idle. Returns the event the FSM sends, and false if there is none
+func (e *SimulatedCar) Step(step time.Duration, orders DistributeOrders.OrderUpdate) (ElevState.EventMessage, bool) {
+	if e.State.Behavior == "idle" {
+		return ElevState.EventMessage{}, false
+	}
+	e.Timer -= step
+	if e.Timer > 0 {
+		return ElevState.EventMessage{}, false
+	}
+
+	switch e.State.Behavior {
+	case "moving":
+		if e.State.Direction == "up" {
+			e.State.Floor++
+		} else {
+			e.State.Floor--
+		}
+		orders.State = e.State
+		if ShouldStop(orders, e.State.Floor, false) {
+			clear := ClearDirection(orders, e.State.Floor)
+			e.State.Behavior, e.Timer = "doorOpen", e.State.DoorTime
+			e.State.Direction = map[string]string{"up": "up", "down": "down", "noHall": "stop"}[clear]
+			return e.event("ClearOrder", clear), true
+		}
+		e.Timer = e.State.TravelTime
+		return e.event("ReachNewFloor", ""), true
+	case "doorOpen":
+		switch ChooseDirection(orders, e.State.Floor) {
+		case elevio.MD_Up:
+			return e.startDriving("up"), true
+		case elevio.MD_Down:
+			return e.startDriving("down"), true
+		default:
+			e.State.Behavior, e.State.Direction = "idle", "stop"
+			return e.event("Stops", ""), true
+		}
+	}
+	return ElevState.EventMessage{}, false
+}
+
+func (e *SimulatedCar) startDriving(direction string) ElevState.EventMessage {
+	e.State.Behavior, e.State.Direction, e.Timer = "moving", direction, e.State.TravelTime
+	return e.event("StartsDriving", "")
+}
+
+//Returns the event with the car's state
+func (e *SimulatedCar) event(eventType string, clear string) ElevState.EventMessage {
+	return ElevState.EventMessage{EventType: eventType, Floor: e.State.Floor, Behavior: e.State.Behavior,
+		Direction: e.State.Direction, ClearOrderDirection: clear}
+}
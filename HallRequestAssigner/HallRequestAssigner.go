@@ -0,0 +1,409 @@
+package HallRequestAssigner
+
+/* The HallRequestAssigner module is a Go port of the hall_request_assigner executable, so DistributeOrders doesn't
+have to start a process for every update. It takes AllStates (defined in ElevState) and returns the hall requests
+each elevator should take, in the same form as the JSON output of the executable.
+Every elevator is simulated moving along from its current state, always moving the one that has used the least
+time so far, and every hall request is given to the first elevator that gets to it. The elevators use the single
+elevator algorithm, where an elevator that stops at a floor clears all requests there. The algorithm is the same as
+in the executable step by step, so both give the same assignments, see Tools/AssignerCompare.
+Each elevator is simulated with the travel and door times it sends in its state, and only takes hall requests at the
+floors it serves (see ElevState/CarParameters.go). A hall request at a floor no elevator serves is not given to any.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"../ElevState"
+)
+
+//Index of the buttons in the requests of a simulated elevator, the hall buttons are the same as in the hall requests
+const (
+	hallUp   = 0
+	hallDown = 1
+	cab      = 2
+)
+
+//Time of an elevator that has nothing more to do. An elevator that serves none of the floors with hall requests left
+//would otherwise keep the least time and be moved forever without getting anywhere
+const never = time.Duration(math.MaxInt64)
+
+//Type of a hall request while it is assigned, assignedTo is empty until an elevator has got to it
+type request struct {
+	active     bool
+	assignedTo string
+}
+
+//Type of an elevator being simulated, with the time it has used so far
+type simulatedElevator struct {
+	id          string
+	behaviour   string
+	floor       int
+	direction   int //1 for up, -1 for down and 0 for stop
+	cabRequests []bool
+	time        time.Duration
+
+	travelDuration   time.Duration //Time to travel between two floors
+	doorOpenDuration time.Duration //Time the door is open at a floor
+	servedFloors     []bool        //The floors it takes hall requests at, every floor if empty
+}
+
+//Type of the requests a simulated elevator sees, used by the single elevator algorithm
+type elevatorRequests struct {
+	floor     int
+	direction int
+	requests  [][3]bool
+}
+
+//Calculates which elevator should take which hall request. Returns the hall requests for every elevator by ID
+func Assign(states ElevState.AllStates) (map[string][][2]bool, error) {
+	if err := validate(states); err != nil {
+		return nil, err
+	}
+
+	elevators := initialElevators(states.States)
+	requests := make([][2]request, len(states.HallRequests))
+	for floor := range states.HallRequests {
+		for button := 0; button < 2; button++ {
+			//A request no elevator can take is left out, otherwise the simulation would never get to it
+			requests[floor][button].active = states.HallRequests[floor][button] && anyServes(elevators, floor)
+		}
+	}
+
+	for e := range elevators {
+		performInitialMove(&elevators[e], requests)
+	}
+
+	for {
+		done := !anyUnassigned(requests)
+		if unvisitedAreImmediatelyAssignable(requests, elevators) {
+			assignImmediate(requests, elevators)
+			done = true
+		}
+		if done {
+			break
+		}
+		sort.Slice(elevators, func(i, j int) bool { return elevators[i].time < elevators[j].time })
+		if elevators[0].time == never { //No elevator can get to the requests left
+			break
+		}
+		performSingleMove(&elevators[0], requests)
+	}
+
+	assignments := make(map[string][][2]bool)
+	for id := range states.States {
+		assignments[id] = make([][2]bool, len(requests))
+	}
+	for floor := range requests {
+		for button := 0; button < 2; button++ {
+			if requests[floor][button].active && requests[floor][button].assignedTo != "" {
+				assignments[requests[floor][button].assignedTo][floor][button] = true
+			}
+		}
+	}
+	return assignments, nil
+}
+
+//Simulates the elevator alone with its cab requests, the hall requests given and the hall request at the floor and
+//button, the same way as Assign. Returns the time it needs to get to that request and the floors it passes on the
+//way, or false if it doesn't serve the floor. Used to explain the assignments, see DistributeOrders/Explain.go
+func TimeToRequest(state ElevState.SingleStates, hallRequests [][2]bool, floor int, button int) (time.Duration, []int, bool) {
+	if validate(ElevState.AllStates{HallRequests: hallRequests, States: map[string]ElevState.SingleStates{"car": state}}) != nil {
+		return 0, nil, false
+	}
+	elevator := initialElevators(map[string]ElevState.SingleStates{"car": state})[0]
+	if floor < 0 || floor >= len(hallRequests) || !elevator.serves(floor) {
+		return 0, nil, false
+	}
+	requests := make([][2]request, len(hallRequests))
+	for f := range hallRequests {
+		for b := 0; b < 2; b++ {
+			requests[f][b].active = (hallRequests[f][b] || (f == floor && b == button)) && elevator.serves(f)
+		}
+	}
+
+	path := []int{}
+	for move := performInitialMove; requests[floor][button].assignedTo == "" && elevator.time != never; move = performSingleMove {
+		start := elevator.floor
+		move(&elevator, requests)
+		if elevator.floor != start {
+			path = append(path, elevator.floor)
+		}
+	}
+	return elevator.time, path, elevator.time != never
+}
+
+//Checks that the states can be simulated without going out of range. The executable crashes on most of these
+func validate(states ElevState.AllStates) error {
+	if len(states.HallRequests) == 0 {
+		return errors.New("no hall requests")
+	}
+	if len(states.States) == 0 {
+		return errors.New("no elevator states")
+	}
+	for id, state := range states.States {
+		if len(state.CabRequests) != len(states.HallRequests) {
+			return fmt.Errorf("elevator %s has %d cab requests, expected %d", id, len(state.CabRequests), len(states.HallRequests))
+		}
+		if state.Floor < 0 || state.Floor >= len(states.HallRequests) {
+			return fmt.Errorf("elevator %s is at floor %d, outside the %d floors", id, state.Floor, len(states.HallRequests))
+		}
+		switch state.Behavior {
+		case "idle", "moving", "doorOpen":
+		default:
+			return fmt.Errorf("elevator %s has unknown behaviour %q", id, state.Behavior)
+		}
+		switch state.Direction {
+		case "up", "down", "stop":
+		default:
+			return fmt.Errorf("elevator %s has unknown direction %q", id, state.Direction)
+		}
+		//A moving elevator is simulated as if it has reached the next floor, which must be in the building
+		if state.Behavior == "moving" && ((state.Direction == "up" && state.Floor == len(states.HallRequests)-1) || (state.Direction == "down" && state.Floor == 0)) {
+			return fmt.Errorf("elevator %s is moving %s from floor %d, out of the building", id, state.Direction, state.Floor)
+		}
+		if len(state.ServedFloors) != 0 && len(state.ServedFloors) != len(states.HallRequests) {
+			return fmt.Errorf("elevator %s serves %d floors, expected %d", id, len(state.ServedFloors), len(states.HallRequests))
+		}
+	}
+	return nil
+}
+
+//Makes the elevators to simulate, sorted by ID. Each one starts a microsecond after the one before, so that the
+//elevators never have used the same time and the one with the lowest ID is moved first
+func initialElevators(states map[string]ElevState.SingleStates) []simulatedElevator {
+	ids := []string{}
+	for id := range states {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	elevators := make([]simulatedElevator, len(ids))
+	for i, id := range ids {
+		state := states[id]
+		direction := 0
+		switch state.Direction {
+		case "up":
+			direction = 1
+		case "down":
+			direction = -1
+		}
+		elevators[i] = simulatedElevator{
+			id:          id,
+			behaviour:   state.Behavior,
+			floor:       state.Floor,
+			direction:   direction,
+			cabRequests: append([]bool{}, state.CabRequests...),
+			time:        time.Duration(i) * time.Microsecond,
+
+			travelDuration:   ElevState.TravelTimeOf(state),
+			doorOpenDuration: ElevState.DoorTimeOf(state),
+			servedFloors:     state.ServedFloors,
+		}
+	}
+	return elevators
+}
+
+//Moves an elevator out of the state it is in: a moving elevator gets to the next floor, and an elevator standing
+//at a floor takes the hall requests there
+func performInitialMove(elevator *simulatedElevator, requests [][2]request) {
+	switch elevator.behaviour {
+	case "doorOpen":
+		elevator.time += elevator.doorOpenDuration / 2
+		fallthrough
+	case "idle":
+		for button := 0; button < 2; button++ {
+			if requests[elevator.floor][button].active && elevator.serves(elevator.floor) {
+				requests[elevator.floor][button].assignedTo = elevator.id
+				elevator.time += elevator.doorOpenDuration
+			}
+		}
+	case "moving":
+		elevator.floor += elevator.direction
+		elevator.time += elevator.travelDuration / 2
+	}
+}
+
+//Moves an elevator one step with the single elevator algorithm, only looking at the hall requests no other elevator
+//has got to yet. The hall requests it clears are assigned to it
+func performSingleMove(elevator *simulatedElevator, requests [][2]request) {
+	e := elevatorRequests{floor: elevator.floor, direction: elevator.direction, requests: make([][3]bool, len(requests))}
+	for floor := range requests {
+		for button := 0; button < 2; button++ {
+			assignedTo := requests[floor][button].assignedTo
+			e.requests[floor][button] = requests[floor][button].active && (assignedTo == "" || assignedTo == elevator.id) && elevator.serves(floor)
+		}
+		e.requests[floor][cab] = elevator.cabRequests[floor]
+	}
+
+	onClearedRequest := func(button int) {
+		if button == cab {
+			elevator.cabRequests[elevator.floor] = false
+		} else {
+			requests[elevator.floor][button].assignedTo = elevator.id
+		}
+	}
+
+	switch elevator.behaviour {
+	case "moving":
+		if e.shouldStop() {
+			elevator.behaviour = "doorOpen"
+			elevator.time += elevator.doorOpenDuration
+			e.clearRequestsAtFloor(onClearedRequest)
+		} else {
+			elevator.floor += elevator.direction
+			elevator.time += elevator.travelDuration
+		}
+	case "idle", "doorOpen":
+		elevator.direction = e.chooseDirection()
+		if elevator.direction == 0 {
+			//It sees no requests, and never will, since the hall requests it sees are only taken by others
+			elevator.behaviour = "idle"
+			elevator.time = never
+		} else {
+			elevator.behaviour = "moving"
+			elevator.floor += elevator.direction
+			elevator.time += elevator.travelDuration
+		}
+	}
+}
+
+//Returns true if there is an active hall request no elevator has got to yet
+func anyUnassigned(requests [][2]request) bool {
+	for floor := range requests {
+		for button := 0; button < 2; button++ {
+			if requests[floor][button].active && requests[floor][button].assignedTo == "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//Returns true if every hall request no elevator has got to yet can be given to an elevator without cab requests
+//standing at its floor. This is never the case while any elevator has cab requests
+func unvisitedAreImmediatelyAssignable(requests [][2]request, elevators []simulatedElevator) bool {
+	for _, elevator := range elevators {
+		if anyCabRequests(elevator) {
+			return false
+		}
+	}
+	for floor := range requests {
+		for button := 0; button < 2; button++ {
+			if requests[floor][button].active && requests[floor][button].assignedTo == "" {
+				atFloor := false
+				for _, elevator := range elevators {
+					if elevator.floor == floor && !anyCabRequests(elevator) && elevator.serves(floor) {
+						atFloor = true
+					}
+				}
+				if !atFloor {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+//Gives the hall requests no elevator has got to yet to the elevators standing at their floor. If there are more
+//than one, the last one in the order they have used time gets it, but all of them open the door
+func assignImmediate(requests [][2]request, elevators []simulatedElevator) {
+	for floor := range requests {
+		for button := 0; button < 2; button++ {
+			if !requests[floor][button].active || requests[floor][button].assignedTo != "" {
+				continue
+			}
+			for e := range elevators {
+				if elevators[e].floor == floor && !anyCabRequests(elevators[e]) && elevators[e].serves(floor) {
+					requests[floor][button].assignedTo = elevators[e].id
+					elevators[e].time += elevators[e].doorOpenDuration
+				}
+			}
+		}
+	}
+}
+
+//Returns true if the elevator takes hall requests at the floor
+func (elevator simulatedElevator) serves(floor int) bool {
+	return len(elevator.servedFloors) == 0 || elevator.servedFloors[floor]
+}
+
+//Returns true if any of the elevators takes hall requests at the floor
+func anyServes(elevators []simulatedElevator, floor int) bool {
+	for _, elevator := range elevators {
+		if elevator.serves(floor) {
+			return true
+		}
+	}
+	return false
+}
+
+//Returns true if the elevator has any cab requests
+func anyCabRequests(elevator simulatedElevator) bool {
+	for _, requested := range elevator.cabRequests {
+		if requested {
+			return true
+		}
+	}
+	return false
+}
+
+//Returns true if there are requests above the elevator
+func (e elevatorRequests) requestsAbove() bool {
+	for floor := e.floor + 1; floor < len(e.requests); floor++ {
+		if e.requests[floor][hallUp] || e.requests[floor][hallDown] || e.requests[floor][cab] {
+			return true
+		}
+	}
+	return false
+}
+
+//Returns true if there are requests below the elevator
+func (e elevatorRequests) requestsBelow() bool {
+	for floor := 0; floor < e.floor; floor++ {
+		if e.requests[floor][hallUp] || e.requests[floor][hallDown] || e.requests[floor][cab] {
+			return true
+		}
+	}
+	return false
+}
+
+//Returns true if a moving elevator should stop at the floor it is at
+func (e elevatorRequests) shouldStop() bool {
+	atEnd := e.floor == 0 || e.floor == len(e.requests)-1
+	switch e.direction {
+	case 1:
+		return e.requests[e.floor][hallUp] || e.requests[e.floor][cab] || !e.requestsAbove() || atEnd
+	case -1:
+		return e.requests[e.floor][hallDown] || e.requests[e.floor][cab] || !e.requestsBelow() || atEnd
+	}
+	return true
+}
+
+//Returns the direction the elevator should go in. An elevator going up keeps going up while there are requests
+//above it, otherwise requests below it are taken first
+func (e elevatorRequests) chooseDirection() int {
+	switch {
+	case e.direction == 1 && e.requestsAbove():
+		return 1
+	case e.requestsBelow():
+		return -1
+	case e.requestsAbove():
+		return 1
+	}
+	return 0
+}
+
+//Clears all requests at the floor of the elevator, and calls onCleared for each of them
+func (e elevatorRequests) clearRequestsAtFloor(onCleared func(button int)) {
+	for button := hallUp; button <= cab; button++ {
+		if e.requests[e.floor][button] {
+			onCleared(button)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package HallRequestAssigner
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"../ElevState"
+)
+
+//Returns the state of an idle car at the floor with no cab requests, serving only the given floors if any
+func idleCar(floor int, floors int, served ...int) ElevState.SingleStates {
+	state := ElevState.SingleStates{Behavior: "idle", Floor: floor, Direction: "stop", CabRequests: make([]bool, floors)}
+	if len(served) > 0 {
+		state.ServedFloors = make([]bool, floors)
+		for _, f := range served {
+			state.ServedFloors[f] = true
+		}
+	}
+	return state
+}
+
+//Runs Assign, and fails instead of hanging if it doesn't return
+func assignWithin(t *testing.T, states ElevState.AllStates) map[string][][2]bool {
+	t.Helper()
+	type result struct {
+		assignments map[string][][2]bool
+		err         error
+	}
+	done := make(chan result, 1)
+	go func() {
+		assignments, err := Assign(states)
+		done <- result{assignments, err}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		return r.assignments
+	case <-time.After(time.Second):
+		t.Fatal("Assign did not return")
+	}
+	return nil
+}
+
+//A car that serves none of the floors with hall requests must not keep the simulation from getting anywhere
+func TestAssignMixedServedFloors(t *testing.T) {
+	const floors = 4
+	cases := []struct {
+		name     string
+		states   map[string]ElevState.SingleStates
+		requests [][2]bool
+		want     map[string][][2]bool
+	}{
+		{
+			name:     "restricted car idle at the same floor",
+			states:   map[string]ElevState.SingleStates{"a": idleCar(0, floors, 0), "b": idleCar(0, floors)},
+			requests: [][2]bool{{}, {}, {}, {true, false}},
+			want:     map[string][][2]bool{"a": {{}, {}, {}, {}}, "b": {{}, {}, {}, {true, false}}},
+		},
+		{
+			name:     "restricted car closer to the call",
+			states:   map[string]ElevState.SingleStates{"a": idleCar(3, floors, 0, 1), "b": idleCar(0, floors)},
+			requests: [][2]bool{{}, {}, {false, true}, {false, true}},
+			want:     map[string][][2]bool{"a": {{}, {}, {}, {}}, "b": {{}, {}, {false, true}, {false, true}}},
+		},
+		{
+			name:     "each car takes the floors it serves",
+			states:   map[string]ElevState.SingleStates{"a": idleCar(0, floors, 0, 1), "b": idleCar(3, floors, 2, 3)},
+			requests: [][2]bool{{true, false}, {false, true}, {true, false}, {false, true}},
+			want:     map[string][][2]bool{"a": {{true, false}, {false, true}, {}, {}}, "b": {{}, {}, {true, false}, {false, true}}},
+		},
+		{
+			name:     "floor no car serves",
+			states:   map[string]ElevState.SingleStates{"a": idleCar(0, floors, 0), "b": idleCar(1, floors, 1)},
+			requests: [][2]bool{{}, {}, {true, false}, {}},
+			want:     map[string][][2]bool{"a": {{}, {}, {}, {}}, "b": {{}, {}, {}, {}}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := assignWithin(t, ElevState.AllStates{HallRequests: c.requests, States: c.states})
+			for id, want := range c.want {
+				for floor := range want {
+					if got[id][floor] != want[floor] {
+						t.Errorf("car %s got %v, want %v", id, got[id], want)
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+//The time to a request is the time the car alone needs to get there with its cab requests on the way, in the same
+//simulation as Assign
+func TestTimeToRequest(t *testing.T) {
+	const floors = 4
+	car := idleCar(0, floors)
+	car.CabRequests[1] = true
+	duration, path, ok := TimeToRequest(car, make([][2]bool, floors), 3, 1)
+	//Travel to floor 1, open the door for the cab request, travel on to floor 3 and open the door for the call
+	want := 3*ElevState.DefaultTravelTime + 2*ElevState.DefaultDoorTime
+	if !ok || duration != want || !reflect.DeepEqual(path, []int{1, 2, 3}) {
+		t.Errorf("got %v through %v (%v), want %v through [1 2 3]", duration, path, ok, want)
+	}
+	if _, _, ok := TimeToRequest(idleCar(0, floors, 0, 1), make([][2]bool, floors), 3, 1); ok {
+		t.Error("got a time for a car that doesn't serve the floor")
+	}
+}
+
+//The states and assignments the hall_request_assigner executable made for them, recorded with
+//Tools/AssignerCompare -RECORD. The Go version must give the same assignments for every case
+func TestAssignGolden(t *testing.T) {
+	data, err := os.ReadFile("testdata/golden.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cases []struct {
+		States      ElevState.AllStates  `json:"states"`
+		Assignments map[string][][2]bool `json:"assignments"`
+	}
+	if err := json.Unmarshal(data, &cases); err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) == 0 {
+		t.Fatal("no cases in the golden file")
+	}
+	for i, c := range cases {
+		got, err := Assign(c.States)
+		if err != nil || !reflect.DeepEqual(got, c.Assignments) {
+			input, _ := json.Marshal(c.States)
+			t.Errorf("case %d: %s\n  expected %v\n  got      %v (error: %v)", i, input, c.Assignments, got, err)
+		}
+	}
+}
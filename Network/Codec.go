@@ -0,0 +1,91 @@
+package Network
+
+/* Codec chooses how the NetworkMessages are encoded on the network (see network/bcast/codec.go). Every elevator sends
+the codecs it can decode in its messages, and the one given with -CODEC is used once every peer on the network has
+said it can decode it. The binary codec is advertised with the fingerprint of NetworkMessage, so it is only used
+when every peer has the same definition of it. Until the first peer update, until every peer has said it, and as
+soon as a peer that can't decode it shows up, the messages are sent as JSON, which every version can read. Messages are decoded whichever codec they come in, so the elevators keep
+hearing each other while they switch.
+*/
+
+import (
+	"../ElevState"
+	"./network/bcast"
+	"fmt"
+)
+
+var PreferredCodec = "json" //The codec to use when all the peers can decode it
+
+var peerCodecs = make(map[string][]string) //The codecs each peer has said it can decode, by ID
+var currentPeers []string                  //The peers on the network, from the last peer update
+var peersKnown bool                        //False until the first peer update
+
+//Records the codecs the peer can decode, and switches codec if needed. A peer only changes its codecs when it is
+//restarted, maybe with another version, so the list is compared to the one it had
+func recordCodecs(peerID string, codecs []string, ID string) {
+	if known, exists := peerCodecs[peerID]; exists && sameCodecs(known, codecs) {
+		return
+	}
+	peerCodecs[peerID] = append([]string{}, codecs...)
+	applyCodec(ID)
+}
+
+func sameCodecs(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//Forgets the peers that are gone, and switches codec if needed
+func updateCodecPeers(peers []string, ID string) {
+	currentPeers, peersKnown = peers, true
+	present := make(map[string]bool)
+	for _, peerID := range peers {
+		present[peerID] = true
+	}
+	for peerID := range peerCodecs {
+		if !present[peerID] {
+			delete(peerCodecs, peerID)
+		}
+	}
+	applyCodec(ID)
+}
+
+//Returns the preferred codec if every peer can decode it, and json otherwise. The peers must be able to decode
+//NetworkMessages with it, so the names are compared as they are advertised, see bcast.AdvertisedCodecs
+func chooseCodec(ID string) string {
+	if !peersKnown {
+		return "json"
+	}
+	wanted := bcast.AdvertisedName(PreferredCodec, ElevState.NetworkMessage{})
+	for _, peerID := range currentPeers {
+		if peerID != ID && !contains(peerCodecs[peerID], wanted) {
+			return "json"
+		}
+	}
+	return PreferredCodec
+}
+
+//Makes bcast use the codec chosen for the network
+func applyCodec(ID string) {
+	codec := chooseCodec(ID)
+	if codec != bcast.CurrentCodec() {
+		bcast.SetCodec(codec)
+		fmt.Println("Sending NetworkMessages as", codec)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package Network
+
+import (
+	"strings"
+	"testing"
+
+	"../ElevState"
+	"./network/bcast"
+)
+
+//The preferred codec is only chosen after the first peer update, and only when every peer advertises the same
+//fingerprint of NetworkMessage for binary
+func TestChooseCodec(t *testing.T) {
+	PreferredCodec = "binary"
+	defer func() {
+		PreferredCodec, peersKnown, currentPeers = "json", false, nil
+		peerCodecs = make(map[string][]string)
+	}()
+	same := bcast.AdvertisedCodecs(ElevState.NetworkMessage{})
+
+	if codec := chooseCodec("a"); codec != "json" {
+		t.Errorf("chose %s before the first peer update, want json", codec)
+	}
+	updateCodecPeers([]string{"a", "b"}, "a")
+	if codec := chooseCodec("a"); codec != "json" {
+		t.Errorf("chose %s before b has said what it can decode, want json", codec)
+	}
+	peerCodecs["b"] = same
+	if codec := chooseCodec("a"); codec != "binary" {
+		t.Errorf("chose %s when b has the same NetworkMessage, want binary", codec)
+	}
+	peerCodecs["b"] = []string{"binary:00000000", "gob", "json"}
+	if codec := chooseCodec("a"); codec != "json" {
+		t.Errorf("chose %s when b has another NetworkMessage, want json", codec)
+	}
+}
+
+//A peer that restarts with another list of codecs of the same length is renegotiated with
+func TestRecordCodecsRenegotiates(t *testing.T) {
+	PreferredCodec = "binary"
+	defer func() {
+		PreferredCodec, peersKnown, currentPeers = "json", false, nil
+		peerCodecs = make(map[string][]string)
+		bcast.SetCodec("json")
+	}()
+	same := bcast.AdvertisedCodecs(ElevState.NetworkMessage{})
+	other := []string{} //The same codecs, with another NetworkMessage
+	for _, name := range same {
+		if strings.HasPrefix(name, "binary:") {
+			name = "binary:00000000"
+		}
+		other = append(other, name)
+	}
+
+	updateCodecPeers([]string{"a", "b"}, "a")
+	recordCodecs("b", same, "a")
+	if codec := bcast.CurrentCodec(); codec != "binary" {
+		t.Fatalf("sending as %s when b has the same NetworkMessage, want binary", codec)
+	}
+	recordCodecs("b", other, "a")
+	if codec := bcast.CurrentCodec(); codec != "json" {
+		t.Errorf("sending as %s after b restarted with another NetworkMessage, want json", codec)
+	}
+}
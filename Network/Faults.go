@@ -0,0 +1,87 @@
+package Network
+
+/* Faults injects packet loss, delay, duplication and reordering into the packets received from the peers (see
+network/conn/faults.go), so it can be shown that orders are still served on a bad network. The faults are given
+with -FAULTS at start-up, or changed from the terminal with "faults", as rules separated by ";":
+  <peer> drop=0.4 delay=20ms jitter=10ms dist=normal dup=0.05 reorder=0.1
+where the peer is the ID of the elevator, or * for all peers without a rule of their own (see network/conn/faults.go).
+Settings that are left out are 0, and a rule without settings removes the faults of the peer. Fault injection is only
+on when -FAULTS is given, -FAULTS=* turns it on without faults, and the packets are only tagged with the ID then, so
+every elevator on a network that injects faults by peer must be started with it.
+*/
+
+import (
+	"./network/conn"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var FaultRules string //The faults injected from the start, none if empty
+
+//Sets the faults in the rules, on top of the ones already set
+func ApplyFaults(rules string) error {
+	for _, rule := range strings.Split(rules, ";") {
+		if strings.TrimSpace(rule) == "" {
+			continue
+		}
+		peer, faults, err := parseFaultRule(rule)
+		if err != nil {
+			return err
+		}
+		if err := conn.SetFaults(peer, faults); err != nil {
+			return fmt.Errorf("faults for %s: %v", peer, err)
+		}
+	}
+	return nil
+}
+
+//Parses one rule, "<peer> <setting>=<value> ..."
+func parseFaultRule(rule string) (string, conn.Faults, error) {
+	fields := strings.Fields(rule)
+	peer, faults := fields[0], conn.Faults{}
+	for _, field := range fields[1:] {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return "", faults, fmt.Errorf("%q is not <setting>=<value>", field)
+		}
+		var err error
+		switch parts[0] {
+		case "drop":
+			faults.Drop, err = strconv.ParseFloat(parts[1], 64)
+		case "dup":
+			faults.Duplicate, err = strconv.ParseFloat(parts[1], 64)
+		case "reorder":
+			faults.Reorder, err = strconv.ParseFloat(parts[1], 64)
+		case "delay":
+			faults.Delay, err = time.ParseDuration(parts[1])
+		case "jitter":
+			faults.Jitter, err = time.ParseDuration(parts[1])
+		case "dist":
+			faults.Distribution = parts[1]
+		default:
+			return "", faults, fmt.Errorf("unknown setting %q, must be drop, dup, reorder, delay, jitter or dist", parts[0])
+		}
+		if err != nil {
+			return "", faults, fmt.Errorf("bad value for %s: %v", parts[0], err)
+		}
+	}
+	return peer, faults, nil
+}
+
+//Prints the faults set for every peer
+func PrintFaults() {
+	rules := conn.FaultRules()
+	if len(rules) == 0 {
+		fmt.Println("No faults injected")
+	}
+	for peer, f := range rules {
+		if f.Distribution == "" {
+			f.Distribution = conn.Uniform
+		}
+		fmt.Printf("%s drop=%g dup=%g reorder=%g delay=%v jitter=%v dist=%s\n", peer, f.Drop, f.Duplicate, f.Reorder, f.Delay, f.Jitter, f.Distribution)
+	}
+	s := conn.FaultStatistics()
+	fmt.Printf("Injected: %d dropped, %d duplicated, %d delayed, %d reordered\n", s.Dropped, s.Duplicated, s.Delayed, s.Reordered)
+}
@@ -0,0 +1,410 @@
+package Network
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"../DistributeOrders"
+	"../ElevState"
+	"../FSM"
+	"../driver/elevio"
+	"./network/conn"
+	"./network/peers"
+)
+
+const lossFloors = 4
+const lossTick = 20 * time.Millisecond //The time between two steps of the simulated cars
+
+//The faults injected into every packet the elevators receive
+var lossFaults = conn.Faults{Drop: 0.4, Duplicate: 0.05, Reorder: 0.1, Delay: 10 * time.Millisecond, Jitter: 5 * time.Millisecond}
+
+//Type of a hall call pressed on an elevator
+type lossCall struct {
+	Elevator string
+	Floor    int
+	Button   elevio.ButtonType
+	Pressed  time.Time
+}
+
+//Type of the lines an elevator writes to the test, see runLossElevator
+type lossReport struct {
+	Event      ElevState.Event               //A call registered or served, or a peer joined or lost
+	HallOrders [][2]ElevState.HallOrderState //The hall orders at the end, when asked for them
+}
+
+//Hall calls are still served on a network that loses packets. Several elevators are started, each in a process of
+//its own since ElevState and DistributeOrders hold the state of one elevator, talking over unicast on the loopback
+//interface with the faults injected into every packet they receive (see network/conn/faults.go). Every elevator runs
+//the real ElevState, DistributeOrders and Network modules, with the FSM logic on a simulated car (see
+//FSM/Simulated.go) in place of the FSM and the driver. The hall buttons are pressed on a simulated elevator server
+//that every elevator's driver is connected to, so the calls go through ElevState the way a button press does. Every
+//call must be served after it was pressed, every elevator must end up with no hall calls, and no elevator may be lost
+//from the peer list
+func TestLossyNetwork(t *testing.T) {
+	if id := os.Getenv("LOSSY_NETWORK_ELEVATOR"); id != "" { //Run as one of the elevators by the test
+		runLossElevator(id)
+		return
+	}
+	if testing.Short() {
+		t.Skip("runs the elevators for several seconds")
+	}
+	const peerCount, calls = 3, 12
+	const interval, wait = 300 * time.Millisecond, 30 * time.Second
+
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	ids := []string{}
+	for i := 1; i <= peerCount; i++ {
+		ids = append(ids, fmt.Sprintf("loss-%d", i))
+	}
+	ports := freePortPairs(t, peerCount)
+	unicastPeers := []string{}
+	for _, port := range ports {
+		unicastPeers = append(unicastPeers, fmt.Sprintf("127.0.0.1:%d", port))
+	}
+
+	var mtx sync.Mutex
+	events := []ElevState.Event{}
+	hallOrders := make(chan lossReport, peerCount)
+	commands := make(map[string]io.WriteCloser)
+	processes := []*exec.Cmd{}
+	defer func() {
+		for _, cmd := range processes {
+			cmd.Process.Kill()
+			cmd.Wait()
+		}
+	}()
+	for i, id := range ids {
+		//Every elevator has its own ports, and keeps its backup and journal in a directory of its own
+		cmd := exec.Command(executable, "-test.run=^TestLossyNetwork$")
+		cmd.Env = append(os.Environ(), "LOSSY_NETWORK_ELEVATOR="+id, "LOSSY_NETWORK_PEERS="+strings.Join(unicastPeers, ","),
+			fmt.Sprintf("LOSSY_NETWORK_PORT=%d", ports[i]))
+		cmd.Dir = filepath.Join(dir, id)
+		cmd.Stderr = os.Stderr
+		os.Mkdir(cmd.Dir, 0755)
+		stdin, _ := cmd.StdinPipe()
+		stdout, _ := cmd.StdoutPipe()
+		if err := cmd.Start(); err != nil {
+			t.Fatal("could not start", id+":", err)
+		}
+		commands[id], processes = stdin, append(processes, cmd)
+		go func() {
+			scanner := bufio.NewScanner(stdout)
+			for scanner.Scan() {
+				var r lossReport
+				if json.Unmarshal(scanner.Bytes(), &r) != nil {
+					continue
+				}
+				if r.HallOrders != nil {
+					hallOrders <- r
+					continue
+				}
+				mtx.Lock()
+				events = append(events, r.Event)
+				mtx.Unlock()
+			}
+		}()
+	}
+	//Lets the elevators start and find each other, otherwise every elevator would serve its own calls alone
+	for start := time.Now(); !allJoined(&mtx, &events, ids); time.Sleep(100 * time.Millisecond) {
+		if time.Since(start) > 10*time.Second {
+			t.Fatal("the elevators did not find each other")
+		}
+	}
+
+	random := rand.New(rand.NewSource(1))
+	pressed := []lossCall{}
+	for i := 0; i < calls; i++ {
+		c := lossCall{Elevator: ids[random.Intn(len(ids))], Floor: random.Intn(lossFloors), Button: elevio.BT_HallUp}
+		if c.Floor == lossFloors-1 || (c.Floor > 0 && random.Intn(2) == 0) {
+			c.Button = elevio.BT_HallDown
+		}
+		c.Pressed = time.Now()
+		fmt.Fprintf(commands[c.Elevator], "press %d %d\n", c.Floor, c.Button)
+		pressed = append(pressed, c)
+		time.Sleep(time.Duration(random.ExpFloat64() * float64(interval)))
+	}
+
+	deadline := time.Now().Add(wait)
+	for time.Now().Before(deadline) && len(unserved(&mtx, &events, pressed)) > 0 {
+		time.Sleep(100 * time.Millisecond)
+	}
+	time.Sleep(time.Second) //Lets the served calls be cleared on every elevator
+
+	for _, c := range unserved(&mtx, &events, pressed) {
+		t.Errorf("the call at floor %d button %d pressed on %s was not served", c.Floor, c.Button, c.Elevator)
+	}
+	for _, id := range ids {
+		fmt.Fprintln(commands[id], "report")
+	}
+	for range ids {
+		select {
+		case r := <-hallOrders:
+			for floor := range r.HallOrders {
+				for button, state := range r.HallOrders[floor] {
+					if state != ElevState.HO_None {
+						t.Errorf("%s still has the call at floor %d button %d in state %d", r.Event.PeerID, floor, button, state)
+					}
+				}
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("an elevator did not report its hall orders")
+		}
+	}
+	mtx.Lock()
+	for _, event := range events {
+		if event.Type == ElevState.EV_PeerLost {
+			t.Error(event.PeerID, "was lost from the peer list on", event.Detail)
+		}
+	}
+	mtx.Unlock()
+	left := len(unserved(&mtx, &events, pressed))
+	t.Logf("%d elevators, loss %g: %d calls pressed, %d served", peerCount, lossFaults.Drop, len(pressed), len(pressed)-left)
+}
+
+//Returns the first of count pairs of ports next to each other that are free on the loopback interface, at least two
+//apart so every elevator gets one for the heartbeats and one for the messages
+func freePortPairs(t *testing.T, count int) []int {
+	ports := []int{}
+	for port := 20000 + rand.Intn(20000); len(ports) < count && port < 65000; port += 2 {
+		first, err := net.ListenPacket("udp4", fmt.Sprintf("127.0.0.1:%d", port))
+		if err != nil {
+			continue
+		}
+		second, err := net.ListenPacket("udp4", fmt.Sprintf("127.0.0.1:%d", port+1))
+		first.Close()
+		if err != nil {
+			continue
+		}
+		second.Close()
+		ports = append(ports, port)
+	}
+	if len(ports) < count {
+		t.Fatal("no free ports for the elevators")
+	}
+	return ports
+}
+
+//Returns true if every elevator has seen every other join the network
+func allJoined(mtx *sync.Mutex, events *[]ElevState.Event, ids []string) bool {
+	mtx.Lock()
+	defer mtx.Unlock()
+	joined := make(map[[2]string]bool)
+	for _, event := range *events {
+		if event.Type == ElevState.EV_PeerJoined {
+			joined[[2]string{event.Detail, event.PeerID}] = true
+		}
+	}
+	for _, id := range ids {
+		for _, other := range ids {
+			if other != id && !joined[[2]string{id, other}] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+//Returns the calls that have not been served on the elevator they were pressed on since they were pressed
+func unserved(mtx *sync.Mutex, events *[]ElevState.Event, pressed []lossCall) []lossCall {
+	mtx.Lock()
+	defer mtx.Unlock()
+	left := []lossCall{}
+	for _, c := range pressed {
+		served := false
+		for _, event := range *events {
+			if event.Type == ElevState.EV_CallServed && event.Detail == c.Elevator && event.Floor == c.Floor &&
+				event.Button == c.Button && event.Time.After(c.Pressed) {
+				served = true
+				break
+			}
+		}
+		if !served {
+			left = append(left, c)
+		}
+	}
+	return left
+}
+
+//Runs one elevator, with the simulated elevator server and car, until the test closes its input. It presses the
+//hall buttons the test writes to it as "press <floor> <button>", writes the calls registered and served and the
+//peers lost on this elevator as JSON lines, and its hall orders when the test writes "report"
+func runLossElevator(id string) {
+	//The modules print their progress, only the reports are written to the test
+	out := json.NewEncoder(os.Stdout)
+	os.Stdout, _ = os.Open(os.DevNull)
+
+	var port int
+	fmt.Sscan(os.Getenv("LOSSY_NETWORK_PORT"), &port)
+	if err := conn.SetTransport(conn.Unicast, "", strings.Split(os.Getenv("LOSSY_NETWORK_PEERS"), ","), port); err != nil {
+		panic(err)
+	}
+	conn.EnableFaults()
+	if err := conn.SetFaults("*", lossFaults); err != nil {
+		panic(err)
+	}
+
+	server := startElevatorServer()
+	elevio.Init(server.addr, lossFloors)
+
+	ElevState.NFLOORS, ElevState.ID, ElevState.DefaultPolicy = lossFloors, id, "timeToServe"
+	ElevState.CarTravelTime, ElevState.CarDoorTime = 200*time.Millisecond, 300*time.Millisecond
+	ElevState.CabRecoveryTime = 500 * time.Millisecond
+	FSM.NFLOORS, FSM.ID, FSM.DoorTime = lossFloors, id, ElevState.CarDoorTime
+	DistributeOrders.ID = id
+
+	//The same channels as in main.go
+	PeerState := make(chan ElevState.NetworkMessage)
+	UpdatedPeers := make(chan peers.PeerUpdate)
+	FSMEventMsg := make(chan ElevState.EventMessage, 10)
+	UpdatedAllStates := make(chan ElevState.AllStates)
+	MsgToNetwork := make(chan ElevState.NetworkMessage)
+	CalculatedHallOrders := make(chan DistributeOrders.OrderUpdate)
+
+	var outMtx sync.Mutex
+	events, _ := ElevState.Subscribe(1000)
+	go func() {
+		for event := range events {
+			switch event.Type {
+			case ElevState.EV_CallServed, ElevState.EV_CallRegistered, ElevState.EV_PeerJoined, ElevState.EV_PeerLost:
+				event.Detail = id //The elevator the event happened on
+				outMtx.Lock()
+				out.Encode(lossReport{Event: event})
+				outMtx.Unlock()
+			}
+		}
+	}()
+
+	go ElevState.UpdateFromNetwork(PeerState, UpdatedAllStates)
+	go ElevState.UpdatePeers(UpdatedPeers, UpdatedAllStates)
+	go ElevState.UpdateFromFSM(FSMEventMsg, MsgToNetwork, UpdatedAllStates)
+	go ElevState.UpdateOrders(UpdatedAllStates, MsgToNetwork)
+	go Network(PeerState, UpdatedPeers, MsgToNetwork, id)
+	go DistributeOrders.DistributeOrders(CalculatedHallOrders, UpdatedAllStates)
+	ElevState.InitElevState()
+	go simulateCar(CalculatedHallOrders, FSMEventMsg)
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var floor, button int
+		switch fields := strings.Fields(scanner.Text()); {
+		case len(fields) == 3 && fields[0] == "press":
+			fmt.Sscan(fields[1]+" "+fields[2], &floor, &button)
+			server.press(floor, elevio.ButtonType(button))
+		case len(fields) == 1 && fields[0] == "report":
+			ElevState.Mtx.Lock()
+			hallOrders := append([][2]ElevState.HallOrderState{}, ElevState.LocalAllStates.HallOrders...)
+			ElevState.Mtx.Unlock()
+			outMtx.Lock()
+			out.Encode(lossReport{Event: ElevState.Event{PeerID: id}, HallOrders: hallOrders})
+			outMtx.Unlock()
+		}
+	}
+}
+
+//Runs the simulated car in real time in place of the FSM: it takes the orders from DistributeOrders and sends the
+//events the FSM would send to ElevState
+func simulateCar(CalculatedOrders <-chan DistributeOrders.OrderUpdate, FSMEventMsg chan<- ElevState.EventMessage) {
+	car := FSM.SimulatedCar{State: ElevState.SingleStates{Behavior: "idle", Floor: 0, Direction: "stop",
+		CabRequests: make([]bool, lossFloors), TravelTime: ElevState.CarTravelTime, DoorTime: ElevState.CarDoorTime}}
+	//Like the FSM when it has found its floor, this makes ElevState send its first message
+	FSMEventMsg <- ElevState.EventMessage{EventType: "Stops", Floor: 0, Behavior: "idle", Direction: "stop", ClearOrderDirection: "noHall"}
+
+	var orders DistributeOrders.OrderUpdate
+	received := false
+	steps := time.NewTicker(lossTick)
+	for {
+		select {
+		case orders = <-CalculatedOrders:
+			received = true
+			if event, ok := car.OnOrders(orders); ok {
+				FSMEventMsg <- event
+			}
+		case <-steps.C:
+			if !received {
+				continue
+			}
+			if event, ok := car.Step(lossTick, orders); ok {
+				FSMEventMsg <- event
+			}
+		}
+	}
+}
+
+//Type of a simulated elevator server, that answers the driver the way the simulator does with the buttons that
+//are pressed and a car at floor 0
+type elevatorServer struct {
+	addr    string
+	mtx     sync.Mutex
+	pressed map[[2]int]bool //The buttons pressed that the driver has not read yet, by floor and button
+}
+
+//Starts a simulated elevator server on a free port on the loopback interface
+func startElevatorServer() *elevatorServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	server := &elevatorServer{addr: listener.Addr().String(), pressed: make(map[[2]int]bool)}
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(connection)
+		}
+	}()
+	return server
+}
+
+//Presses the button. It is held down until the driver reads it, and released the next time it is read, so no
+//press is missed while the driver is busy
+func (s *elevatorServer) press(floor int, button elevio.ButtonType) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.pressed[[2]int{floor, int(button)}] = true
+}
+
+//Answers the commands from the driver. Only the commands that read something are answered, the lamps and the motor
+//are not simulated
+func (s *elevatorServer) serve(connection net.Conn) {
+	defer connection.Close()
+	var command [4]byte
+	for {
+		if _, err := io.ReadFull(connection, command[:]); err != nil {
+			return
+		}
+		reply := [4]byte{command[0], 0, 0, 0}
+		switch command[0] {
+		case 6: //Button
+			s.mtx.Lock()
+			if s.pressed[[2]int{int(command[2]), int(command[1])}] {
+				reply[1] = 1
+				delete(s.pressed, [2]int{int(command[2]), int(command[1])})
+			}
+			s.mtx.Unlock()
+		case 7: //Floor sensor
+			reply[1] = 1
+		case 8, 9: //Stop button and obstruction
+		default:
+			continue
+		}
+		connection.Write(reply[:])
+	}
+}
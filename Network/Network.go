@@ -7,6 +7,7 @@ to all it's peers. It both gets and sends it's information to the ElevState modu
 import (
 	"../ElevState"
 	"./network/bcast"
+	"./network/conn"
 	"./network/peers"
 	"fmt"
 	"time"
@@ -19,28 +20,61 @@ func Network(PeerState chan<- ElevState.NetworkMessage, UpdatedPeers chan<- peer
 
 	// We make a channel for receiving id of peers on the network
 	peerUpdateCh := make(chan peers.PeerUpdate)
-	//Make a channel that contains the bool tha enables or disables the peerUpdateCh
+	//Make a channel that contains the bool tha enables or disables the peerUpdateCh. The peer heartbeat is always on,
+	//faulty elevators are marked as unavailable instead of leaving the network
 	peerTxEnable := make(chan bool)
 
+	//While fault injection is on every packet sent carries the ID, so the faults injected into the packets from a peer
+	//can be set by its ID, see network/conn/faults.go
+	conn.SetSourceID(ID)
+
+	//The heartbeats and the messages are sent on two channels. With unicast every elevator has its own ports, see
+	//Network/network/conn/transport.go
+	peerPort, messagePort := conn.ChannelPort(0, 15432), conn.ChannelPort(1, 16789)
+
 	//Put the channels into the peers modules function
-	go peers.Transmitter(15432, ID, peerTxEnable)
-	go peers.Receiver(15432, peerUpdateCh)
+	go peers.Transmitter(peerPort, ID, peerTxEnable)
+	go peers.Receiver(peerPort, peerUpdateCh)
 
 	// We make channels for sending and receiving our NetworkMessage struct
 	Tx := make(chan ElevState.NetworkMessage)
 	Rx := make(chan ElevState.NetworkMessage)
 
 	//Enter the channels into the bcast functions
-	go bcast.Transmitter(16789, Tx)
-	go bcast.Receiver(16789, Rx)
+	go bcast.Transmitter(messagePort, Tx)
+	go bcast.Receiver(messagePort, Rx)
 
-	fmt.Println("Started Network Module")
+	fmt.Println("Started Network Module") //Sends json until the first peer update, see Codec.go
 
 	//Inits the variable that is used for saving the last NetworkMessage received from ElevState
 	// and a timer that makes the Transmitter send the LastPackageFromLocal every 100 Millisecond   -- Our fault tolerance solution
 	timeOut := time.NewTimer(100 * time.Millisecond)
 	lastPackageFromLocal := ElevState.NetworkMessage{}
 
+	//Every message is numbered, so the peers can drop the ones that arrive late or out of order. The epoch makes
+	//the messages after a restart newer than the ones before it, even though the numbering starts over
+	epoch := time.Now().UnixNano()
+	seq := uint64(0)
+
+	//Clear orders and new calls are queued and sent with every message until every peer has acknowledged them,
+	//see Reliable.go
+	reliable := newReliableQueue(epoch)
+	//Returns the last package from ElevState with the newest hall orders, the next number, the queued events and
+	//the acknowledgements
+	nextPackage := func() ElevState.NetworkMessage {
+		//Adds the newest hall orders and copies of the peers' cab requests, the peers depend on seeing them change
+		message := ElevState.FreshNetworkMessage(lastPackageFromLocal)
+		seq++
+		message.Epoch = epoch
+		message.Seq = seq
+		message.Codecs = bcast.AdvertisedCodecs(message)
+		//Asks the peers for their copies of this elevator's cab requests until one has arrived, see ElevState/CabBackup.go
+		if ElevState.AwaitingCabBackups() && message.MessageType == "StateUpdate" {
+			message.MessageType = "CabBackupRequest"
+		}
+		return reliable.stamp(message)
+	}
+
 	for {
 		select {
 
@@ -49,33 +83,52 @@ func Network(PeerState chan<- ElevState.NetworkMessage, UpdatedPeers chan<- peer
 			fmt.Printf("  Peers:    %q\n", peersInfo.Peers)
 			fmt.Printf("  New:      %q\n", peersInfo.New)
 			fmt.Printf("  Lost:     %q\n", peersInfo.Lost)
+			fmt.Printf("  Stale:    %v\n", ElevState.StaleDropCounts())
+			updateCodecPeers(peersInfo.Peers, ID)
+			reliable.setPeers(peersInfo.Peers, ID)
+			reliable.prune(time.Now())
 			//Sends the updated peers information to the ElevState
 			UpdatedPeers <- peersInfo
 
 		case received := <-Rx: //send the received NetworkMessage to ElevState
+			recordCodecs(received.ID, received.Codecs, ID)
+			if received.ID != ID {
+				reliable.acknowledge(received, ID)
+				reliable.prune(time.Now())
+				received = reliable.receive(received)
+			}
 			PeerState <- received
+			//A rejoining peer gets the copies of its cab requests at once instead of with the next heartbeat. An
+			//elevator that is rejoining itself leaves it to its heartbeat, so two of them don't answer each other forever
+			if received.MessageType == "CabBackupRequest" && received.ID != ID && lastPackageFromLocal.ID != "" && !ElevState.AwaitingCabBackups() {
+				Tx <- nextPackage()
+			}
 
 		case packageFromLocal := <-MsgToNetwork: //The case that handles transmitting to Network
 			// Update lastPackageFromLocal to the new message
 			lastPackageFromLocal = packageFromLocal
+			//A clear order or new call is queued as an event and sent at once, it is sent with the heartbeat after that
+			if isCritical(packageFromLocal) {
+				reliable.enqueue(packageFromLocal, time.Now())
+				lastPackageFromLocal.MessageType = "StateUpdate"
+				Tx <- nextPackage()
+			} else if ElevState.AwaitingCabBackups() { //The request for the cab request copies is sent at once as well
+				Tx <- nextPackage()
+			}
 
 		case <-timeOut.C: //Handles the message when the timer runs out
-
-			if lastPackageFromLocal.MessageType == "MotorProblems" { //if it is has motor problems it disconnects from the network
-				peerTxEnable <- false
-				fmt.Println("Disconnect from network on resend")
-
-			} else if lastPackageFromLocal.MessageType == "MotorWorksAgain" { //if the motor starts working again it reconnects
-				peerTxEnable <- true
-
-			} else { //for all other cases it check that the message has an ID and then sends it
-				if lastPackageFromLocal.ID != "" {
-					Tx <- lastPackageFromLocal
-				}
-
-				//finally it resests the timer to 100 Millisecond
-				timeOut.Reset(100 * time.Millisecond)
+			//An elevator with motor problems stays on the network, the peers see that it is unavailable from the
+			//availability in its state and don't give it hall requests (see ElevState/Availability.go)
+			if lastPackageFromLocal.ID != "" { //checks that the message has an ID and then sends it
+				//Sends the events that are not acknowledged by every peer again
+				reliable.prune(time.Now())
+				retransmitted := uint64(len(reliable.queue))
+				countReliable(func(s *ReliableStats) { s.Retransmitted += retransmitted })
+				Tx <- nextPackage()
 			}
+
+			//finally it resests the timer to 100 Millisecond
+			timeOut.Reset(100 * time.Millisecond)
 		}
 	}
 }
@@ -0,0 +1,182 @@
+package Network
+
+/* Reliable makes sure the critical messages, clear orders and new calls, reach every peer once and in order. The
+periodic heartbeat only sends the last NetworkMessage from ElevState, so a clear order followed by a state update
+within 100 ms would never be sent. Instead the critical messages are numbered and queued as events, and every message
+this elevator sends carries all the events in the queue on top of its newest state, until every peer has
+acknowledged them. A new event is sent at once, and then with every heartbeat, so any one message that gets through
+brings a peer up to date, and a retransmission never rolls the state back.
+
+Every message carries the number of the last event ElevState has applied from every peer (Acks), see
+ElevState/CriticalEvents.go. The events are only acknowledged once the message they came with is in ElevState's
+states, so an event in a message that ElevState drops is sent again until one that is taken gets through. The
+events carry no state, the hall orders and cab requests travel in every message. Messages that are older than one
+already received from the same peer are passed on without events, since ElevState drops them. An event that is not
+acknowledged within criticalTimeout is given up, and the peers skip it.
+*/
+
+import (
+	"../ElevState"
+	"sync"
+	"time"
+)
+
+const (
+	criticalTimeout   = 3 * time.Second //How long an event is sent to peers that don't acknowledge it
+	maxQueuedCritical = 64              //The oldest event is given up when more are queued
+)
+
+//Counts of the events sent and received, see ReliableStatistics
+type ReliableStats struct {
+	Queued        uint64 //Clear orders and new calls from ElevState
+	Retransmitted uint64 //Times an event was sent again
+	Acknowledged  uint64 //Events acknowledged by every peer
+	GaveUp        uint64 //Events dropped before every peer acknowledged them
+	Delivered     uint64 //Events from the peers applied in order by ElevState
+	Duplicates    uint64 //Events from the peers that were already applied
+	OutOfOrder    uint64 //Messages from the peers older than one already received, passed on without events
+	Skipped       uint64 //Events the peers gave up before they were applied here
+}
+
+//Type that holds an event in the queue and when it was queued
+type queuedEvent struct {
+	event  ElevState.CriticalEvent
+	queued time.Time
+}
+
+//Type that holds the position in the messages from a peer
+type peerPosition struct {
+	epoch int64
+	seq   uint64
+}
+
+//Type that holds the queue of events to send and the position in the messages from every peer
+type reliableQueue struct {
+	epoch     int64
+	next      uint64 //The number of the next event
+	queue     []queuedEvent
+	peers     []string                //The peers that have to acknowledge, not this elevator
+	acked     map[string]uint64       //The last event acknowledged by every peer
+	positions map[string]peerPosition //The position in the messages from every peer
+}
+
+var reliableStats ReliableStats
+var reliableStatsMtx = sync.Mutex{}
+
+//Returns the counts of the events sent and received
+func ReliableStatistics() ReliableStats {
+	reliableStatsMtx.Lock()
+	stats := reliableStats
+	reliableStatsMtx.Unlock()
+	applied := ElevState.EventStatistics()
+	stats.Delivered, stats.Duplicates, stats.Skipped = applied.Applied, applied.Duplicates, applied.Skipped
+	return stats
+}
+
+func countReliable(count func(*ReliableStats)) {
+	reliableStatsMtx.Lock()
+	count(&reliableStats)
+	reliableStatsMtx.Unlock()
+}
+
+func newReliableQueue(epoch int64) *reliableQueue {
+	return &reliableQueue{epoch: epoch, next: 1, acked: make(map[string]uint64), positions: make(map[string]peerPosition)}
+}
+
+//Returns true if the message from ElevState must reach every peer
+func isCritical(message ElevState.NetworkMessage) bool {
+	return message.MessageType == "ClearOrder" || message.MessageType == "NewCall"
+}
+
+//Queues the clear order or new call in the message as an event
+func (r *reliableQueue) enqueue(message ElevState.NetworkMessage, now time.Time) {
+	if len(r.queue) >= maxQueuedCritical {
+		r.queue = r.queue[1:]
+		countReliable(func(s *ReliableStats) { s.GaveUp++ })
+	}
+	event := ElevState.CriticalEvent{Critical: r.next, MessageType: message.MessageType, Floor: message.EventFloor,
+		ClearOrderDirection: message.ClearOrderDirection}
+	r.next++
+	r.queue = append(r.queue, queuedEvent{event: event, queued: now})
+	countReliable(func(s *ReliableStats) { s.Queued++ })
+}
+
+//Adds the events in the queue and the acknowledgements of the events ElevState has applied to an outgoing message
+func (r *reliableQueue) stamp(message ElevState.NetworkMessage) ElevState.NetworkMessage {
+	message.Events = nil
+	for _, queued := range r.queue {
+		message.Events = append(message.Events, queued.event)
+	}
+	message.Acks = ElevState.CriticalAcks()
+	return message
+}
+
+//Sets the peers that have to acknowledge the events, and forgets the peers that are gone
+func (r *reliableQueue) setPeers(peers []string, ID string) {
+	present := make(map[string]bool)
+	r.peers = nil
+	for _, peerID := range peers {
+		present[peerID] = true
+		if peerID != ID {
+			r.peers = append(r.peers, peerID)
+		}
+	}
+	for peerID := range r.acked {
+		if !present[peerID] {
+			delete(r.acked, peerID)
+		}
+	}
+	for peerID := range r.positions {
+		if !present[peerID] {
+			delete(r.positions, peerID)
+		}
+	}
+}
+
+//Takes the acknowledgement of this elevator's events from a peer's message
+func (r *reliableQueue) acknowledge(message ElevState.NetworkMessage, ID string) {
+	for _, ack := range message.Acks {
+		if ack.PeerID == ID && ack.Epoch == r.epoch && ack.Critical > r.acked[message.ID] {
+			r.acked[message.ID] = ack.Critical
+		}
+	}
+}
+
+//Drops the events every peer has acknowledged, and the ones that have been sent for too long
+func (r *reliableQueue) prune(now time.Time) {
+	for len(r.queue) > 0 {
+		head := r.queue[0]
+		acknowledged := true
+		for _, peerID := range r.peers {
+			if r.acked[peerID] < head.event.Critical {
+				acknowledged = false
+				break
+			}
+		}
+		switch {
+		case acknowledged:
+			countReliable(func(s *ReliableStats) { s.Acknowledged++ })
+		case now.Sub(head.queued) > criticalTimeout:
+			countReliable(func(s *ReliableStats) { s.GaveUp++ })
+		default:
+			return
+		}
+		r.queue = r.queue[1:]
+	}
+}
+
+//Returns the message from a peer as it should be passed on to ElevState, without events if it is older than one
+//already received. ElevState takes the events it has not applied yet, see ElevState/CriticalEvents.go
+func (r *reliableQueue) receive(message ElevState.NetworkMessage) ElevState.NetworkMessage {
+	if message.Epoch == 0 { //From an elevator that doesn't number its messages
+		return message
+	}
+	position, exists := r.positions[message.ID]
+	if exists && (message.Epoch < position.epoch || (message.Epoch == position.epoch && message.Seq <= position.seq)) {
+		countReliable(func(s *ReliableStats) { s.OutOfOrder++ })
+		message.Events = nil
+		return message
+	}
+	r.positions[message.ID] = peerPosition{epoch: message.Epoch, seq: message.Seq}
+	return message
+}
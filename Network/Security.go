@@ -0,0 +1,66 @@
+package Network
+
+/* Security authenticates the traffic between the elevators with pre-shared keys, so a host on the LAN can't inject
+hall calls, clear orders or fake a peer heartbeat. The keys are read from the file given with -KEYFILE, one on each
+line as "<id> <secret>", where the id is 0-255 and the first key is used for sending. Every packet on the bcast and
+peers ports is sealed with an HMAC, or encrypted with AES-GCM with -ENCRYPT, and packets that are not sealed with one
+of the keys are dropped and counted (see network/conn/secure.go), as are packets that were recorded and sent again.
+To rotate keys, add the new key last in the file on every elevator, type "keys" in the terminal to reload it, then
+move it first and reload, then remove the old one.
+*/
+
+import (
+	"./network/conn"
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var KeyFile string //The file with the pre-shared keys, no authentication if empty
+var Encrypt bool   //Encrypt the packets as well as authenticating them
+
+//Reads the key file and starts using the keys. Keeps the keys in use if the file can't be read
+func LoadKeys() error {
+	if KeyFile == "" {
+		return conn.SetKeys(nil, false)
+	}
+	keys, err := readKeyFile(KeyFile)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no keys in %s", KeyFile)
+	}
+	if err := conn.SetKeys(keys, Encrypt); err != nil {
+		return fmt.Errorf("error in %s: %v", KeyFile, err)
+	}
+	fmt.Println("Loaded", len(keys), "keys from", KeyFile+", sending with key", keys[0].ID)
+	return nil
+}
+
+//Returns the keys in the file, in the order they are in it. Empty lines and lines starting with # are skipped
+func readKeyFile(path string) ([]conn.Key, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	keys := []conn.Key{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.SplitN(text, " ", 2)
+		id, err := strconv.ParseUint(fields[0], 10, 8)
+		if err != nil || len(fields) < 2 || strings.TrimSpace(fields[1]) == "" {
+			return nil, fmt.Errorf("%s line %d: must be \"<id 0-255> <secret>\"", path, line)
+		}
+		keys = append(keys, conn.Key{ID: byte(id), Secret: []byte(strings.TrimSpace(fields[1]))})
+	}
+	return keys, scanner.Err()
+}
@@ -4,13 +4,15 @@ import (
 	"../conn"
 	"encoding/json"
 	"fmt"
-	"net"
 	"reflect"
 	"strings"
+	"time"
 )
 
-// Encodes received values from `chans` into type-tagged JSON, then broadcasts
-// it on `port`
+// Encodes received values from `chans` into type-tagged JSON, or another codec
+// set with SetCodec (see codec.go), then broadcasts it on `port` with the
+// transport set in conn. Messages too
+// long for one datagram are split into fragments, see fragment.go
 func Transmitter(port int, chans ...interface{}) {
 	checkArgs(chans...)
 
@@ -29,41 +31,121 @@ func Transmitter(port int, chans ...interface{}) {
 		typeNames[i] = reflect.TypeOf(ch).Elem().String()
 	}
 
-	conn := conn.DialBroadcastUDP(port)
-	addr, _ := net.ResolveUDPAddr("udp4", fmt.Sprintf("255.255.255.255:%d", port))
+	addrs := conn.Destinations(port)
+	conn := conn.Dial(0) // Sends from a free port, so with unicast only the receivers get the packets to `port`
+	sender := newSenderID()
+	number := uint32(0)
 	for {
 		chosen, value, _ := reflect.Select(selectCases)
-		buf, _ := json.Marshal(value.Interface())
-		conn.WriteTo([]byte(typeNames[chosen]+string(buf)), addr)
+		message, err := EncodeMessage(CurrentCodec(), value.Interface())
+		if err != nil {
+			reportError(&stats.EncodeErrors, fmt.Errorf("could not encode %s: %v", typeNames[chosen], err))
+			continue
+		}
+		number++
+		datagrams, err := fragment(message, sender, number)
+		if err != nil {
+			reportError(&stats.EncodeErrors, fmt.Errorf("could not send %s: %v", typeNames[chosen], err))
+			continue
+		}
+		for _, datagram := range datagrams {
+			for _, addr := range addrs {
+				conn.WriteTo(datagram, addr)
+			}
+		}
+		count(&stats.Sent, 1)
+		if len(datagrams) > 1 {
+			count(&stats.SentFragments, uint64(len(datagrams)))
+		}
 	}
 }
 
-// Matches type-tagged JSON received on `port` to element types of `chans`, then
-// sends the decoded value on the corresponding channel
+// Matches type-tagged messages received on `port` to element types of `chans`,
+// then sends the decoded value on the corresponding channel. Messages in every
+// codec are decoded, whichever one the transmitters in this process use. Fragments are collected
+// until the whole message has arrived. Messages that can't be decoded are
+// counted and reported, see Statistics
 func Receiver(port int, chans ...interface{}) {
 	checkArgs(chans...)
 
-	var buf [1024]byte
-	conn := conn.DialBroadcastUDP(port)
+	var buf [maxDatagramSize]byte
+	fragments := newReassembler()
+	conn := conn.Dial(port)
+	// The fragments of a large message arrive back to back, make room for them
+	if udp, ok := conn.(interface{ SetReadBuffer(int) error }); ok {
+		udp.SetReadBuffer(receiveBufferSize)
+	}
 	for {
-		n, _, _ := conn.ReadFrom(buf[0:])
-		for _, ch := range chans {
-			T := reflect.TypeOf(ch).Elem()
-			typeName := T.String()
-			if strings.HasPrefix(string(buf[0:n])+"{", typeName) {
-				v := reflect.New(T)
-				json.Unmarshal(buf[len(typeName):n], v.Interface())
+		n, _, err := conn.ReadFrom(buf[0:])
+		if err != nil {
+			continue
+		}
+		message := buf[0:n]
+		if isFragment(message) {
+			count(&stats.ReceivedFragments, 1)
+			whole, complete, err := fragments.add(message, time.Now())
+			if err != nil {
+				reportError(&stats.DecodeErrors, fmt.Errorf("broken fragment: %v", err))
+			}
+			if !complete {
+				continue
+			}
+			message = whole
+		}
+		receive(message, chans)
+	}
+}
 
-				reflect.Select([]reflect.SelectCase{{
-					Dir:  reflect.SelectSend,
-					Chan: reflect.ValueOf(ch),
-					Send: reflect.Indirect(v),
-				}})
+// Decodes the message and sends it on the channel of its type. In json a type
+// name can be the start of another one, so the message goes to the first
+// channel it decodes for
+func receive(message []byte, chans []interface{}) {
+	name, data, codec, err := splitMessage(message)
+	if err != nil {
+		reportError(&stats.DecodeErrors, err)
+		return
+	}
+
+	var decodeErr error
+	for _, ch := range chans {
+		T := reflect.TypeOf(ch).Elem()
+		typeName := T.String()
+		if codec != nil && name == typeName {
+			v := reflect.New(T)
+			if err := codec.Unmarshal(data, v.Interface()); err != nil {
+				reportError(&stats.DecodeErrors, fmt.Errorf("could not decode %s of %d bytes: %v", typeName, len(message), err))
+				return
 			}
+			send(ch, v)
+			return
 		}
+		if codec == nil && strings.HasPrefix(string(message)+"{", typeName) {
+			v := reflect.New(T)
+			if err := json.Unmarshal(message[len(typeName):], v.Interface()); err != nil {
+				decodeErr = fmt.Errorf("could not decode %s of %d bytes: %v", typeName, len(message), err)
+				continue
+			}
+			send(ch, v)
+			return
+		}
+	}
+	if decodeErr != nil {
+		reportError(&stats.DecodeErrors, decodeErr)
+	} else {
+		count(&stats.Unknown, 1)
 	}
 }
 
+// Sends the decoded value on the channel
+func send(ch interface{}, v reflect.Value) {
+	reflect.Select([]reflect.SelectCase{{
+		Dir:  reflect.SelectSend,
+		Chan: reflect.ValueOf(ch),
+		Send: reflect.Indirect(v),
+	}})
+	count(&stats.Received, 1)
+}
+
 // Checks that args to Tx'er/Rx'er are valid:
 //  All args must be channels
 //  Element types of channels must be encodable with JSON
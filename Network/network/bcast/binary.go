@@ -0,0 +1,369 @@
+package bcast
+
+import (
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// The binary codec writes the values without field names: integers as
+// varints, strings and slices with their length first, and the exported fields
+// of structs in order. Both ends must have the same definition of the type, so
+// every message starts with a 4 byte fingerprint of the type's fields, and a
+// message from a transmitter with another definition is a decode error instead
+// of garbage. Types that implement encoding.BinaryMarshaler, like time.Time,
+// are written with it
+type binaryCodec struct{}
+
+var binaryMarshaler = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+var binaryUnmarshaler = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+
+// Returns true if values of the type are written with their own MarshalBinary
+// and read with UnmarshalBinary
+func marshalsItself(t reflect.Type) bool {
+	return t.Kind() != reflect.Ptr && t.Implements(binaryMarshaler) && reflect.PtrTo(t).Implements(binaryUnmarshaler)
+}
+
+func (binaryCodec) Marshal(v interface{}) ([]byte, error) {
+	value := reflect.ValueOf(v)
+	buf := make([]byte, 4, 256)
+	binary.BigEndian.PutUint32(buf, fingerprint(value.Type()))
+	return appendValue(buf, value)
+}
+
+func (binaryCodec) Unmarshal(data []byte, v interface{}) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return errors.New("binary: can only decode into a pointer")
+	}
+	if len(data) < 4 {
+		return errors.New("binary: message shorter than its fingerprint")
+	}
+	if binary.BigEndian.Uint32(data) != fingerprint(value.Elem().Type()) {
+		return fmt.Errorf("binary: %s is defined differently by the sender", value.Elem().Type())
+	}
+	r := &binaryReader{data: data[4:]}
+	if err := r.readValue(value.Elem()); err != nil {
+		return err
+	}
+	if len(r.data) != 0 {
+		return fmt.Errorf("binary: %d bytes left after the value", len(r.data))
+	}
+	return nil
+}
+
+var fingerprints sync.Map // Fingerprints already made, by type
+
+// Returns a hash of the names and kinds of the type and all the types in it
+func fingerprint(t reflect.Type) uint32 {
+	if f, exists := fingerprints.Load(t); exists {
+		return f.(uint32)
+	}
+	h := fnv.New32a()
+	describe(h, t, map[reflect.Type]bool{})
+	fingerprints.Store(t, h.Sum32())
+	return h.Sum32()
+}
+
+func describe(w interface{ Write([]byte) (int, error) }, t reflect.Type, seen map[reflect.Type]bool) {
+	fmt.Fprint(w, t.Kind(), "(")
+	defer fmt.Fprint(w, ")")
+	if marshalsItself(t) || seen[t] {
+		fmt.Fprint(w, t.String())
+		return
+	}
+	seen[t] = true
+	switch t.Kind() {
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.PkgPath == "" {
+				fmt.Fprint(w, f.Name, ":")
+				describe(w, f.Type, seen)
+			}
+		}
+	case reflect.Map:
+		describe(w, t.Key(), seen)
+		describe(w, t.Elem(), seen)
+	case reflect.Slice, reflect.Array, reflect.Ptr:
+		describe(w, t.Elem(), seen)
+	}
+}
+
+func appendValue(buf []byte, v reflect.Value) ([]byte, error) {
+	if marshalsItself(v.Type()) {
+		data, err := v.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		return append(appendUvarint(buf, uint64(len(data))), data...), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendVarint(buf, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendUvarint(buf, v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(v.Float()))
+		return append(buf, b[:]...), nil
+	case reflect.String:
+		return append(appendUvarint(buf, uint64(v.Len())), v.String()...), nil
+
+	case reflect.Slice:
+		// The length is written one higher, so 0 means nil
+		if v.IsNil() {
+			return append(buf, 0), nil
+		}
+		buf = appendUvarint(buf, uint64(v.Len())+1)
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return append(buf, v.Bytes()...), nil
+		}
+		return appendElements(buf, v)
+	case reflect.Array:
+		return appendElements(buf, v)
+
+	case reflect.Map:
+		if v.IsNil() {
+			return append(buf, 0), nil
+		}
+		buf = appendUvarint(buf, uint64(v.Len())+1)
+		keys := v.MapKeys()
+		if v.Type().Key().Kind() == reflect.String { // The same map always gives the same bytes
+			sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		}
+		var err error
+		for _, key := range keys {
+			if buf, err = appendValue(buf, key); err != nil {
+				return nil, err
+			}
+			if buf, err = appendValue(buf, v.MapIndex(key)); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+
+	case reflect.Struct:
+		var err error
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath != "" {
+				continue
+			}
+			if buf, err = appendValue(buf, v.Field(i)); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+
+	case reflect.Ptr:
+		if v.IsNil() {
+			return append(buf, 0), nil
+		}
+		return appendValue(append(buf, 1), v.Elem())
+	}
+	return nil, fmt.Errorf("binary: can't encode %s", v.Type())
+}
+
+func appendElements(buf []byte, v reflect.Value) ([]byte, error) {
+	var err error
+	for i := 0; i < v.Len(); i++ {
+		if buf, err = appendValue(buf, v.Index(i)); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func appendUvarint(buf []byte, x uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutUvarint(b[:], x)]...)
+}
+
+func appendVarint(buf []byte, x int64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutVarint(b[:], x)]...)
+}
+
+// Reads values written by appendValue, and returns an error instead of
+// panicking on a short or broken message
+type binaryReader struct {
+	data []byte
+}
+
+var errShort = errors.New("binary: message ends in the middle of a value")
+
+func (r *binaryReader) readUvarint() (uint64, error) {
+	x, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		return 0, errShort
+	}
+	r.data = r.data[n:]
+	return x, nil
+}
+
+func (r *binaryReader) readVarint() (int64, error) {
+	x, n := binary.Varint(r.data)
+	if n <= 0 {
+		return 0, errShort
+	}
+	r.data = r.data[n:]
+	return x, nil
+}
+
+func (r *binaryReader) readBytes(n uint64) ([]byte, error) {
+	if n > uint64(len(r.data)) {
+		return nil, errShort
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b, nil
+}
+
+// Reads the length of a slice or map, which is written one higher so 0 means
+// nil. Every element takes at least a byte, except empty structs and arrays,
+// so a longer length than there are bytes left is an error
+func (r *binaryReader) readLength(elem reflect.Type) (int, bool, error) {
+	n, err := r.readUvarint()
+	if err != nil || n == 0 {
+		return 0, false, err
+	}
+	n--
+	if elem.Size() > 0 && n > uint64(len(r.data)) {
+		return 0, false, errShort
+	}
+	return int(n), true, nil
+}
+
+func (r *binaryReader) readValue(v reflect.Value) error {
+	if marshalsItself(v.Type()) {
+		n, err := r.readUvarint()
+		if err != nil {
+			return err
+		}
+		data, err := r.readBytes(n)
+		if err != nil {
+			return err
+		}
+		return v.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(data)
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := r.readBytes(1)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b[0] != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, err := r.readVarint()
+		if err != nil {
+			return err
+		}
+		v.SetInt(x)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		x, err := r.readUvarint()
+		if err != nil {
+			return err
+		}
+		v.SetUint(x)
+	case reflect.Float32, reflect.Float64:
+		b, err := r.readBytes(8)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+	case reflect.String:
+		n, err := r.readUvarint()
+		if err != nil {
+			return err
+		}
+		b, err := r.readBytes(n)
+		if err != nil {
+			return err
+		}
+		v.SetString(string(b))
+
+	case reflect.Slice:
+		n, present, err := r.readLength(v.Type().Elem())
+		if err != nil || !present {
+			v.Set(reflect.Zero(v.Type()))
+			return err
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := r.readBytes(uint64(n))
+			if err != nil {
+				return err
+			}
+			v.SetBytes(append([]byte{}, b...))
+			return nil
+		}
+		v.Set(reflect.MakeSlice(v.Type(), n, n))
+		return r.readElements(v)
+	case reflect.Array:
+		return r.readElements(v)
+
+	case reflect.Map:
+		n, present, err := r.readLength(v.Type().Key())
+		if err != nil || !present {
+			v.Set(reflect.Zero(v.Type()))
+			return err
+		}
+		v.Set(reflect.MakeMapWithSize(v.Type(), n))
+		for i := 0; i < n; i++ {
+			key, value := reflect.New(v.Type().Key()).Elem(), reflect.New(v.Type().Elem()).Elem()
+			if err := r.readValue(key); err != nil {
+				return err
+			}
+			if err := r.readValue(value); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, value)
+		}
+
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath != "" {
+				continue
+			}
+			if err := r.readValue(v.Field(i)); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Ptr:
+		b, err := r.readBytes(1)
+		if err != nil {
+			return err
+		}
+		if b[0] == 0 {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		v.Set(reflect.New(v.Type().Elem()))
+		return r.readValue(v.Elem())
+
+	default:
+		return fmt.Errorf("binary: can't decode %s", v.Type())
+	}
+	return nil
+}
+
+func (r *binaryReader) readElements(v reflect.Value) error {
+	for i := 0; i < v.Len(); i++ {
+		if err := r.readValue(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
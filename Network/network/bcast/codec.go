@@ -0,0 +1,183 @@
+package bcast
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A Codec turns the values sent on the channels into bytes and back
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// The codecs by name. Each has an ID that is sent with the message, so the
+// receivers can decode messages from transmitters using any of them:
+//  json    the type name followed by JSON, the original format, without a header
+//  gob     encoding/gob, every message carries a description of its type
+//  binary  the compact encoding in binary.go, the fields without their names
+var codecs = map[string]struct {
+	id    byte
+	codec Codec
+}{
+	"json":   {0, jsonCodec{}},
+	"gob":    {1, gobCodec{}},
+	"binary": {2, binaryCodec{}},
+}
+
+// Messages in another codec than json start with codecMagic, the ID of the
+// codec, the length of the type name and the type name. Like fragments, they
+// start with a zero byte so they are never taken for JSON
+const codecMagic = "\x00bcc"
+const codecHeaderSize = len(codecMagic) + 2
+
+var currentCodec = "json"
+var codecMtx sync.Mutex
+
+// Returns the names of all codecs, sorted
+func CodecNames() []string {
+	names := []string{}
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the names of all codecs as they are advertised to the peers for
+// values like sample. The binary codec is advertised with the fingerprint of
+// the type, "binary:<fingerprint>", since it can only decode values from
+// transmitters with the same definition of the type
+func AdvertisedCodecs(sample interface{}) []string {
+	names := CodecNames()
+	for i, name := range names {
+		names[i] = AdvertisedName(name, sample)
+	}
+	return names
+}
+
+// Returns the name of the codec as it is advertised for values like sample,
+// see AdvertisedCodecs
+func AdvertisedName(name string, sample interface{}) string {
+	if name == "binary" {
+		return fmt.Sprintf("binary:%08x", fingerprint(reflect.TypeOf(sample)))
+	}
+	return name
+}
+
+// Returns an error if there is no codec with the name
+func CheckCodec(name string) error {
+	if _, exists := codecs[name]; !exists {
+		return fmt.Errorf("unknown codec %q, must be one of: %s", name, strings.Join(CodecNames(), ", "))
+	}
+	return nil
+}
+
+// Sets the codec all transmitters in this process use from the next message.
+// Receivers decode all codecs at all times
+func SetCodec(name string) error {
+	if err := CheckCodec(name); err != nil {
+		return err
+	}
+	codecMtx.Lock()
+	currentCodec = name
+	codecMtx.Unlock()
+	return nil
+}
+
+// Returns the name of the codec the transmitters use
+func CurrentCodec() string {
+	codecMtx.Lock()
+	defer codecMtx.Unlock()
+	return currentCodec
+}
+
+// Returns the message the transmitters send for the value with the codec,
+// before it is split into fragments
+func EncodeMessage(codecName string, v interface{}) ([]byte, error) {
+	c, exists := codecs[codecName]
+	if !exists {
+		return nil, fmt.Errorf("unknown codec %q", codecName)
+	}
+	typeName := reflect.TypeOf(v).String()
+	data, err := c.codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if codecName == "json" {
+		return append([]byte(typeName), data...), nil
+	}
+	if len(typeName) > 255 {
+		return nil, fmt.Errorf("type name %s is too long", typeName)
+	}
+	message := make([]byte, 0, codecHeaderSize+len(typeName)+len(data))
+	message = append(message, codecMagic...)
+	message = append(message, c.id, byte(len(typeName)))
+	message = append(message, typeName...)
+	return append(message, data...), nil
+}
+
+// Decodes a message made by EncodeMessage into v, which must be a pointer to a
+// value of the type the message was made from
+func DecodeMessage(message []byte, v interface{}) error {
+	typeName := reflect.TypeOf(v).Elem().String()
+	name, data, codec, err := splitMessage(message)
+	if err != nil {
+		return err
+	}
+	if codec == nil {
+		if !strings.HasPrefix(string(message), typeName) {
+			return fmt.Errorf("message is not a %s", typeName)
+		}
+		return json.Unmarshal(message[len(typeName):], v)
+	}
+	if name != typeName {
+		return fmt.Errorf("message is a %s, not a %s", name, typeName)
+	}
+	return codec.Unmarshal(data, v)
+}
+
+// Returns the type name, the encoded value and the codec of a message in
+// another codec than json. A message in json has no header and a nil codec
+func splitMessage(message []byte) (string, []byte, Codec, error) {
+	if !bytes.HasPrefix(message, []byte(codecMagic)) {
+		return "", nil, nil, nil
+	}
+	if len(message) < codecHeaderSize || len(message) < codecHeaderSize+int(message[codecHeaderSize-1]) {
+		return "", nil, nil, errors.New("message shorter than its header")
+	}
+	id, nameLength := message[len(codecMagic)], int(message[codecHeaderSize-1])
+	for name, c := range codecs {
+		if c.id == id && name != "json" {
+			return string(message[codecHeaderSize : codecHeaderSize+nameLength]), message[codecHeaderSize+nameLength:], c.codec, nil
+		}
+	}
+	return "", nil, nil, fmt.Errorf("unknown codec %d, the sender runs a newer version", id)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// Every message is encoded on its own, since any of them can be lost, so each
+// one carries the description of its type and is larger than it would be in a
+// stream
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
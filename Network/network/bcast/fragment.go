@@ -0,0 +1,177 @@
+package bcast
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Messages longer than fragmentSize are split into fragments, so that every
+// datagram fits in one Ethernet frame and no message depends on IP
+// fragmentation. A fragment is fragmentMagic followed by a header and a part of
+// the message:
+//  sender  uint32  random number identifying the transmitter
+//  message uint32  number of the message from this transmitter
+//  index   uint16  number of the fragment in the message
+//  count   uint16  number of fragments in the message
+// Shorter messages are sent as they are, so they can still be read by
+// receivers that don't know about fragments. A type name never starts with a
+// zero byte, so fragments are never mistaken for whole messages.
+const (
+	fragmentMagic      = "\x00bcf"
+	fragmentHeaderSize = len(fragmentMagic) + 12
+	fragmentSize       = 1400
+	fragmentPayload    = fragmentSize - fragmentHeaderSize
+
+	// Largest message that is sent or reassembled, bigger ones are reported
+	// and dropped
+	maxMessageSize = 1 << 20
+
+	// Largest datagram UDP over IPv4 can carry, the receive buffer size
+	maxDatagramSize = 65507
+
+	// Size of the socket buffer for datagrams not read yet
+	receiveBufferSize = 4 << 20
+
+	// How long the fragments of a message are kept while waiting for the rest
+	reassemblyTimeout = 1 * time.Second
+
+	// How many messages can be partly received at the same time
+	maxPartialMessages = 32
+)
+
+// Splits the message into datagrams of at most fragmentSize bytes. A message
+// that fits in one is returned as it is
+func fragment(message []byte, sender uint32, number uint32) ([][]byte, error) {
+	if len(message) <= fragmentSize {
+		return [][]byte{message}, nil
+	}
+	if len(message) > maxMessageSize {
+		return nil, fmt.Errorf("message of %d bytes is larger than the maximum of %d bytes", len(message), maxMessageSize)
+	}
+
+	count := (len(message) + fragmentPayload - 1) / fragmentPayload
+	datagrams := make([][]byte, 0, count)
+	for index := 0; index < count; index++ {
+		end := (index + 1) * fragmentPayload
+		if end > len(message) {
+			end = len(message)
+		}
+		datagram := make([]byte, fragmentHeaderSize, fragmentHeaderSize+end-index*fragmentPayload)
+		copy(datagram, fragmentMagic)
+		header := datagram[len(fragmentMagic):]
+		binary.BigEndian.PutUint32(header[0:], sender)
+		binary.BigEndian.PutUint32(header[4:], number)
+		binary.BigEndian.PutUint16(header[8:], uint16(index))
+		binary.BigEndian.PutUint16(header[10:], uint16(count))
+		datagrams = append(datagrams, append(datagram, message[index*fragmentPayload:end]...))
+	}
+	return datagrams, nil
+}
+
+// Returns true if the datagram is a fragment of a message
+func isFragment(datagram []byte) bool {
+	return len(datagram) >= len(fragmentMagic) && string(datagram[:len(fragmentMagic)]) == fragmentMagic
+}
+
+// Returns a random number identifying a transmitter
+func newSenderID() uint32 {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return uint32(time.Now().UnixNano())
+	}
+	return binary.BigEndian.Uint32(b[:])
+}
+
+type fragmentKey struct {
+	sender, message uint32
+}
+
+// A message that has been partly received
+type partialMessage struct {
+	parts    [][]byte
+	received int
+	started  time.Time
+}
+
+// Collects fragments until all of a message has been received
+type reassembler struct {
+	partial map[fragmentKey]*partialMessage
+}
+
+func newReassembler() *reassembler {
+	return &reassembler{partial: make(map[fragmentKey]*partialMessage)}
+}
+
+// Adds the fragment, and returns the whole message when it is the last one
+// missing. Messages that are not complete within reassemblyTimeout are dropped
+// and counted as incomplete
+func (r *reassembler) add(datagram []byte, now time.Time) ([]byte, bool, error) {
+	r.expire(now)
+
+	if len(datagram) < fragmentHeaderSize {
+		return nil, false, errors.New("fragment shorter than its header")
+	}
+	header := datagram[len(fragmentMagic):fragmentHeaderSize]
+	key := fragmentKey{binary.BigEndian.Uint32(header[0:]), binary.BigEndian.Uint32(header[4:])}
+	index := int(binary.BigEndian.Uint16(header[8:]))
+	count := int(binary.BigEndian.Uint16(header[10:]))
+	if count == 0 || index >= count {
+		return nil, false, fmt.Errorf("fragment %d of %d", index, count)
+	}
+	if count*fragmentPayload > maxMessageSize+fragmentPayload {
+		return nil, false, fmt.Errorf("message of %d fragments is larger than the maximum of %d bytes", count, maxMessageSize)
+	}
+
+	p, exists := r.partial[key]
+	if !exists {
+		if len(r.partial) >= maxPartialMessages {
+			r.dropOldest()
+		}
+		p = &partialMessage{parts: make([][]byte, count), started: now}
+		r.partial[key] = p
+	}
+	if len(p.parts) != count {
+		return nil, false, fmt.Errorf("fragment says %d fragments, an earlier one said %d", count, len(p.parts))
+	}
+	if p.parts[index] != nil {
+		return nil, false, nil // A duplicate
+	}
+	p.parts[index] = append([]byte{}, datagram[fragmentHeaderSize:]...)
+	p.received++
+	if p.received < count {
+		return nil, false, nil
+	}
+
+	delete(r.partial, key)
+	message := []byte{}
+	for _, part := range p.parts {
+		message = append(message, part...)
+	}
+	return message, true, nil
+}
+
+// Drops the messages that have waited too long for their fragments
+func (r *reassembler) expire(now time.Time) {
+	for key, p := range r.partial {
+		if now.Sub(p.started) > reassemblyTimeout {
+			delete(r.partial, key)
+			countIncomplete()
+		}
+	}
+}
+
+// Drops the message that was started first, to make room for a new one
+func (r *reassembler) dropOldest() {
+	var oldest fragmentKey
+	first := true
+	for key, p := range r.partial {
+		if first || p.started.Before(r.partial[oldest].started) {
+			oldest, first = key, false
+		}
+	}
+	delete(r.partial, oldest)
+	countIncomplete()
+}
@@ -0,0 +1,63 @@
+package bcast
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Counts of what the transmitters and receivers have done, see Statistics
+type Stats struct {
+	Sent              uint64 // Messages sent
+	SentFragments     uint64 // Datagrams sent for messages that were split
+	Received          uint64 // Messages received and sent on a channel
+	ReceivedFragments uint64 // Fragments received
+	Incomplete        uint64 // Messages dropped because fragments were missing
+	EncodeErrors      uint64 // Messages that could not be encoded or were too large
+	DecodeErrors      uint64 // Messages that could not be decoded, or broken fragments
+	Unknown           uint64 // Messages of a type no channel takes
+	LastError         string
+}
+
+var stats Stats
+var statsMtx sync.Mutex
+
+// How often errors are printed, they are counted every time
+const errorPrintInterval = 1 * time.Second
+
+var lastErrorPrint time.Time
+
+// Returns the counts of all transmitters and receivers in this process
+func Statistics() Stats {
+	statsMtx.Lock()
+	defer statsMtx.Unlock()
+	return stats
+}
+
+func count(counter *uint64, n uint64) {
+	statsMtx.Lock()
+	*counter += n
+	statsMtx.Unlock()
+}
+
+func countIncomplete() {
+	count(&stats.Incomplete, 1)
+}
+
+// Counts the error and prints it, at most once every errorPrintInterval so a
+// peer sending bad messages every 100 ms doesn't flood the terminal
+func reportError(counter *uint64, err error) {
+	statsMtx.Lock()
+	*counter++
+	stats.LastError = err.Error()
+	print := time.Since(lastErrorPrint) >= errorPrintInterval
+	if print {
+		lastErrorPrint = time.Now()
+	}
+	total := stats.EncodeErrors + stats.DecodeErrors
+	statsMtx.Unlock()
+
+	if print {
+		fmt.Printf("bcast: %v (%d errors so far)\n", err, total)
+	}
+}
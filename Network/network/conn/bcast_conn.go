@@ -18,5 +18,7 @@ func DialBroadcastUDP(port int) net.PacketConn {
 	conn, _ := net.FilePacketConn(f)
 	f.Close()
 
-	return conn
+	// Packets are authenticated when keys are set, see secure.go, and faults
+	// are injected into the ones that open when they are on, see faults.go
+	return faulty(secure(conn))
 }
@@ -0,0 +1,325 @@
+package conn
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Faults can be injected into the packets received on all connections, to
+// test the elevators on a bad network without iptables. Fault injection is
+// off until EnableFaults is called, and the packets are sent as they are. The
+// faults are set per peer, by the ID of the elevator the packets come from, or
+// "*" for the peers without a rule of their own. The address can't be used,
+// since the transmitters send from a new port every time they start. Instead,
+// while fault injection is on, every packet sent starts with a tag with the
+// sender's ID, set with SetSourceID, which the receivers take off again:
+//  sourceMagic  4 bytes
+//  ID length    1 byte
+//  ID
+//  packet
+// The tag is added before the packet is sealed and the faults are injected
+// after it is opened (see secure.go), so when keys are set the ID is
+// authenticated along with the packet, and a peer can't pick the faults of
+// another. Packets without a tag only get the faults of "*". Packets from a
+// peer with a rule are
+//  dropped     with probability Drop
+//  duplicated  with probability Duplicate, the copy is delayed on its own
+//  delayed     by a time drawn from Delay and Jitter, see Faults.delay
+//  reordered   with probability Reorder, held back reorderDelay more than the
+//              delay, so the packets after it overtake it
+// Rules can be changed at any time with SetFaults and ClearFaults, and apply
+// from the next packet received.
+const (
+	Uniform     = "uniform"     // Delay plus or minus up to Jitter
+	Normal      = "normal"      // Mean Delay, standard deviation Jitter
+	Exponential = "exponential" // Mean Delay, Jitter is not used
+
+	reorderDelay   = 20 * time.Millisecond
+	maxHeldPackets = 4096 // Packets delayed beyond this are dropped
+
+	sourceMagic = "\x00src"
+)
+
+// The faults injected into the packets from a peer
+type Faults struct {
+	Drop         float64       // Probability that a packet is dropped, 0 to 1
+	Duplicate    float64       // Probability that a packet is received twice
+	Reorder      float64       // Probability that a packet is overtaken by the ones after it
+	Delay        time.Duration // Mean delay of a packet
+	Jitter       time.Duration // Spread of the delay
+	Distribution string        // How the delay is spread, Uniform if empty
+}
+
+// Counts of the faults injected on all connections
+type FaultStats struct {
+	Dropped    uint64
+	Duplicated uint64
+	Delayed    uint64
+	Reordered  uint64
+}
+
+var faultMtx sync.Mutex
+var faultRules = make(map[string]Faults)
+var faultStats FaultStats
+var faultsEnabled bool // Packets are only tagged and faults only set while it is on
+var sourceTag []byte   // The tag put in front of every packet sent, none if empty
+
+// Turns fault injection on for all connections: the packets sent from now on
+// are tagged with the source ID, and faults can be set with SetFaults. All
+// peers on a network that injects faults by peer must turn it on
+func EnableFaults() {
+	faultMtx.Lock()
+	defer faultMtx.Unlock()
+	faultsEnabled = true
+}
+
+// Sets the ID of this elevator, which every packet sent while fault injection
+// is on is tagged with so the receivers can choose the faults for it. An empty
+// ID sends the packets without a tag
+func SetSourceID(id string) error {
+	if len(id) > 255 {
+		return fmt.Errorf("ID %q is too long", id)
+	}
+	faultMtx.Lock()
+	defer faultMtx.Unlock()
+	if id == "" {
+		sourceTag = nil
+		return nil
+	}
+	sourceTag = append([]byte(sourceMagic), byte(len(id)))
+	sourceTag = append(sourceTag, id...)
+	return nil
+}
+
+// Returns the ID in the tag of the packet and the packet without the tag. A
+// packet without a tag is returned as it is with an empty ID
+func untag(packet []byte) (string, []byte) {
+	header := len(sourceMagic) + 1
+	if len(packet) < header || string(packet[:len(sourceMagic)]) != sourceMagic || len(packet) < header+int(packet[header-1]) {
+		return "", packet
+	}
+	end := header + int(packet[header-1])
+	return string(packet[header:end]), packet[end:]
+}
+
+// Sets the faults injected into the packets from the peer. Faults{} removes
+// the peer's rule
+func SetFaults(peer string, f Faults) error {
+	switch {
+	case strings.TrimSpace(peer) == "":
+		return fmt.Errorf("empty peer, use * for all peers")
+	case f.Drop < 0 || f.Drop > 1 || f.Duplicate < 0 || f.Duplicate > 1 || f.Reorder < 0 || f.Reorder > 1:
+		return fmt.Errorf("probabilities must be from 0 to 1")
+	case f.Delay < 0 || f.Jitter < 0:
+		return fmt.Errorf("negative delay")
+	case f.Distribution != "" && f.Distribution != Uniform && f.Distribution != Normal && f.Distribution != Exponential:
+		return fmt.Errorf("unknown delay distribution %q, must be %s, %s or %s", f.Distribution, Uniform, Normal, Exponential)
+	}
+	faultMtx.Lock()
+	defer faultMtx.Unlock()
+	if !faultsEnabled {
+		return fmt.Errorf("fault injection is off")
+	}
+	if f == (Faults{}) {
+		delete(faultRules, peer)
+	} else {
+		faultRules[peer] = f
+	}
+	return nil
+}
+
+// Removes the faults of all peers
+func ClearFaults() {
+	faultMtx.Lock()
+	defer faultMtx.Unlock()
+	faultRules = make(map[string]Faults)
+}
+
+// Returns the faults set for every peer
+func FaultRules() map[string]Faults {
+	faultMtx.Lock()
+	defer faultMtx.Unlock()
+	rules := make(map[string]Faults)
+	for peer, f := range faultRules {
+		rules[peer] = f
+	}
+	return rules
+}
+
+// Returns the counts of the faults injected on all connections
+func FaultStatistics() FaultStats {
+	faultMtx.Lock()
+	defer faultMtx.Unlock()
+	return faultStats
+}
+
+// Returns the faults for packets from the elevator with the ID, and false if
+// there are none
+func faultsFor(id string) (Faults, bool) {
+	faultMtx.Lock()
+	defer faultMtx.Unlock()
+	if len(faultRules) == 0 {
+		return Faults{}, false
+	}
+	if f, exists := faultRules[id]; exists && id != "" {
+		return f, true
+	}
+	f, exists := faultRules["*"]
+	return f, exists
+}
+
+func countFault(counter *uint64) {
+	faultMtx.Lock()
+	*counter++
+	faultMtx.Unlock()
+}
+
+// Returns a delay drawn from the distribution, never below 0
+func (f Faults) delay() time.Duration {
+	var d float64
+	switch f.Distribution {
+	case Normal:
+		d = float64(f.Delay) + rand.NormFloat64()*float64(f.Jitter)
+	case Exponential:
+		d = rand.ExpFloat64() * float64(f.Delay)
+	default:
+		d = float64(f.Delay) + (2*rand.Float64()-1)*float64(f.Jitter)
+	}
+	if d < 0 {
+		return 0
+	}
+	return time.Duration(d)
+}
+
+// A packet that is held back until it is due
+type heldPacket struct {
+	data []byte
+	addr net.Addr
+	due  time.Time
+}
+
+// A connection that tags the packets it sends with the ID of this elevator
+// while fault injection is on, and injects the faults into the packets it
+// receives. Tagged packets are untagged whether it is on or not. The delayed packets are
+// returned by ReadFrom when they are due, so the read deadline set by the
+// caller is kept here and the one on the connection is set to wake up for them
+type faultyPacketConn struct {
+	net.PacketConn
+	buf      []byte
+	held     []heldPacket
+	deadline time.Time
+}
+
+func faulty(c net.PacketConn) net.PacketConn {
+	return &faultyPacketConn{PacketConn: c, buf: make([]byte, 65536)}
+}
+
+func (c *faultyPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		now := time.Now()
+		if p, ok := c.takeDue(now); ok {
+			return copy(b, p.data), p.addr, nil
+		}
+
+		wake := c.deadline
+		for _, p := range c.held {
+			if wake.IsZero() || p.due.Before(wake) {
+				wake = p.due
+			}
+		}
+		c.PacketConn.SetReadDeadline(wake)
+		n, addr, err := c.PacketConn.ReadFrom(c.buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && (c.deadline.IsZero() || time.Now().Before(c.deadline)) {
+				continue // Woken up for a held packet
+			}
+			return 0, addr, err
+		}
+
+		id, packet := untag(c.buf[:n])
+		f, exists := faultsFor(id)
+		if !exists && len(c.held) == 0 {
+			return copy(b, packet), addr, nil
+		}
+		c.inject(append([]byte{}, packet...), addr, f, time.Now())
+	}
+}
+
+func (c *faultyPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	faultMtx.Lock()
+	tagged := b
+	if faultsEnabled && sourceTag != nil {
+		tagged = append(append([]byte{}, sourceTag...), b...)
+	}
+	faultMtx.Unlock()
+	if _, err := c.PacketConn.WriteTo(tagged, addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Holds the packet back with the faults, or drops it
+func (c *faultyPacketConn) inject(data []byte, addr net.Addr, f Faults, now time.Time) {
+	if rand.Float64() < f.Drop {
+		countFault(&faultStats.Dropped)
+		return
+	}
+	copies := 1
+	if rand.Float64() < f.Duplicate {
+		copies = 2
+		countFault(&faultStats.Duplicated)
+	}
+	for i := 0; i < copies; i++ {
+		delay := f.delay()
+		if rand.Float64() < f.Reorder {
+			delay += reorderDelay
+			countFault(&faultStats.Reordered)
+		}
+		if delay > 0 {
+			countFault(&faultStats.Delayed)
+		}
+		if len(c.held) >= maxHeldPackets {
+			countFault(&faultStats.Dropped)
+			continue
+		}
+		c.held = append(c.held, heldPacket{data: data, addr: addr, due: now.Add(delay)})
+	}
+}
+
+// Removes and returns the held packet that was due first, if one is due
+func (c *faultyPacketConn) takeDue(now time.Time) (heldPacket, bool) {
+	first := -1
+	for i, p := range c.held {
+		if !p.due.After(now) && (first < 0 || p.due.Before(c.held[first].due)) {
+			first = i
+		}
+	}
+	if first < 0 {
+		return heldPacket{}, false
+	}
+	p := c.held[first]
+	c.held = append(c.held[:first], c.held[first+1:]...)
+	return p, true
+}
+
+func (c *faultyPacketConn) SetReadDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+func (c *faultyPacketConn) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return c.PacketConn.SetWriteDeadline(t)
+}
+
+// Makes room for packets that are not read yet, if the connection can
+func (c *faultyPacketConn) SetReadBuffer(bytes int) error {
+	if udp, ok := c.PacketConn.(interface{ SetReadBuffer(int) error }); ok {
+		return udp.SetReadBuffer(bytes)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package conn
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+// The faults are chosen by the ID in the tag of the packet, whatever port it
+// was sent from, and the receiver gets the packet without the tag
+func TestFaultsByID(t *testing.T) {
+	defer disableFaults()
+	EnableFaults()
+	if err := SetFaults("lossy", Faults{Drop: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	receiver := faulty(listenUDP(t))
+	receiver.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	for _, id := range []string{"lossy", "clean", "lossy", "clean"} { //A new port for every packet
+		SetSourceID(id)
+		sender := faulty(listenUDP(t))
+		if _, err := sender.WriteTo([]byte("from "+id), receiver.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		sender.Close()
+	}
+
+	buf := make([]byte, 100)
+	for i := 0; i < 2; i++ {
+		n, _, err := receiver.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != "from clean" {
+			t.Errorf("received %q, want the packets from clean without the tag", buf[:n])
+		}
+	}
+	if _, _, err := receiver.ReadFrom(buf); err == nil {
+		t.Errorf("received %q, want the packets from lossy dropped", buf)
+	}
+}
+
+// Packets are sent as they are while fault injection is off, and no faults can
+// be set
+func TestNoTagWhenFaultsOff(t *testing.T) {
+	defer disableFaults()
+	SetSourceID("a")
+	if err := SetFaults("*", Faults{Drop: 1}); err == nil {
+		t.Error("faults were set while fault injection is off")
+	}
+
+	raw := listenUDP(t)
+	sender := faulty(listenUDP(t))
+	if _, err := sender.WriteTo([]byte("packet"), raw.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 100)
+	raw.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	n, _, err := raw.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "packet" {
+		t.Errorf("sent %q, want the packet without a tag", buf[:n])
+	}
+}
+
+// With keys the tag is sealed along with the packet, so a packet whose ID is
+// changed on the way is dropped instead of getting the faults of that ID
+func TestFaultTagIsAuthenticated(t *testing.T) {
+	defer disableFaults()
+	defer SetKeys(nil, false)
+	if err := SetKeys([]Key{{ID: 1, Secret: []byte("secret")}}, false); err != nil {
+		t.Fatal(err)
+	}
+	EnableFaults()
+	SetSourceID("lossy")
+	if err := SetFaults("lossy", Faults{Drop: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	raw := listenUDP(t)
+	sender := faulty(secure(listenUDP(t)))
+	if _, err := sender.WriteTo([]byte("packet"), raw.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1000)
+	raw.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	n, _, err := raw.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet := buf[:n]
+	if bytes.HasPrefix(packet, []byte(sourceMagic)) || !bytes.Contains(packet, []byte("lossy")) {
+		t.Fatalf("sent %q, want the tag inside the sealed packet", packet)
+	}
+
+	receiver := faulty(secure(listenUDP(t)))
+	receiver.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	before := SecurityStatistics()
+	forged := bytes.Replace(packet, []byte("lossy"), []byte("clean"), 1)
+	if _, err := raw.WriteTo(forged, receiver.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := receiver.ReadFrom(buf); err == nil {
+		t.Errorf("received %q from a packet with a forged tag", buf)
+	}
+	if badTags := SecurityStatistics().BadTag - before.BadTag; badTags != 1 {
+		t.Errorf("counted %d packets with a bad tag, want 1", badTags)
+	}
+}
+
+// Turns fault injection off again after a test
+func disableFaults() {
+	ClearFaults()
+	SetSourceID("")
+	faultMtx.Lock()
+	faultsEnabled = false
+	faultMtx.Unlock()
+}
+
+func listenUDP(t *testing.T) net.PacketConn {
+	c, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
@@ -0,0 +1,32 @@
+// +build !windows
+
+package conn
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// Packets to the multicast group only go to the hosts on the local network
+const multicastTTL = 1
+
+func DialMulticastUDP(port int, group net.IP) net.PacketConn {
+	s, _ := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, syscall.IPPROTO_UDP)
+	syscall.SetsockoptInt(s, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+	syscall.SetsockoptInt(s, syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, multicastTTL)
+	syscall.SetsockoptInt(s, syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP, 1)
+	syscall.Bind(s, &syscall.SockaddrInet4{Port: port})
+
+	mreq := &syscall.IPMreq{}
+	copy(mreq.Multiaddr[:], group.To4())
+	syscall.SetsockoptIPMreq(s, syscall.IPPROTO_IP, syscall.IP_ADD_MEMBERSHIP, mreq)
+
+	f := os.NewFile(uintptr(s), "")
+	conn, _ := net.FilePacketConn(f)
+	f.Close()
+
+	// Packets are authenticated when keys are set, see secure.go, and faults
+	// are injected into the ones that open when they are on, see faults.go
+	return faulty(secure(conn))
+}
@@ -0,0 +1,330 @@
+package conn
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Packets are authenticated with a pre-shared key when keys are set with
+// SetKeys. Every packet sent is sealed with the first key, and packets sealed
+// with any of the keys are accepted, so keys can be rotated without stopping
+// the network: add the new key last on every host, then move it first on every
+// host, then remove the old one. Packets that are not sealed with a known key
+// are dropped and counted, see SecurityStatistics. A sealed packet is:
+//  secureMagic  4 bytes
+//  flags        1 byte, flagEncrypted if the payload is encrypted
+//  key ID       1 byte
+//  sender       8 bytes, chosen at random when the process starts
+//  counter      8 bytes, increased for every packet the sender seals
+//  time         8 bytes, when the packet was sealed, in nanoseconds since 1970
+//  nonce        12 bytes, only if encrypted
+//  payload
+//  tag          32 bytes HMAC-SHA256 of all before it, or 16 bytes AES-GCM tag
+//               if encrypted
+// With encryption the payload is encrypted with AES-256-GCM and the header is
+// authenticated along with it. Both forms are accepted whether this host
+// encrypts or not.
+//
+// A packet that was recorded and sent again is dropped: the time must be less
+// than maxPacketAge from the time here, so the clocks of the hosts must agree
+// to within that, and every counter is only accepted once from a sender. The
+// counters may arrive out of order, but not more than replayWindow behind the
+// highest one from the sender.
+const (
+	secureMagic      = "\x00sec"
+	secureHeaderSize = len(secureMagic) + 2 + 24
+	flagEncrypted    = 1
+	macSize          = sha256.Size
+	maxPacketAge     = 30 * time.Second
+	replayWindow     = 1024
+)
+
+// A pre-shared key. The secret can be any length, the keys used for HMAC and
+// encryption are derived from it
+type Key struct {
+	ID     byte
+	Secret []byte
+}
+
+type derivedKey struct {
+	mac  []byte
+	aead cipher.AEAD
+}
+
+// The counters received from a sender, see replayed
+type senderCounters struct {
+	highest  uint64
+	seen     map[uint64]bool // The counters from highest-replayWindow up
+	lastSeen time.Time
+}
+
+// Counts of the packets that were opened and dropped
+type SecurityStats struct {
+	Accepted        uint64 // Packets with a valid tag
+	Unauthenticated uint64 // Packets without a seal, while keys are set
+	UnknownKey      uint64 // Packets sealed with a key that is not set here
+	BadTag          uint64 // Packets whose tag or encryption didn't check out
+	NoKeys          uint64 // Sealed packets received while no keys are set
+	Stale           uint64 // Packets with a valid tag that were too old or too far behind
+	Replayed        uint64 // Packets with a valid tag and a counter received before
+}
+
+var securityMtx sync.RWMutex
+var sendKey byte
+var keys map[byte]derivedKey // nil when packets are not authenticated
+var encrypt bool
+var securityStats SecurityStats
+var senderID = randomSenderID()
+var sendCounter uint64                      // The counter of the last packet sealed, used atomically
+var received = map[uint64]*senderCounters{} // By sender
+
+// Sets the keys used to seal and open packets on all connections, the first
+// one is used for sending. With no keys packets are sent and received as they
+// are. Can be called at any time to rotate keys
+func SetKeys(newKeys []Key, encryptPayload bool) error {
+	derived := make(map[byte]derivedKey)
+	for _, key := range newKeys {
+		if len(key.Secret) == 0 {
+			return errors.New("empty secret")
+		}
+		if _, exists := derived[key.ID]; exists {
+			return errors.New("key ID used twice")
+		}
+		block, _ := aes.NewCipher(derive(key.Secret, "encrypt"))
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return err
+		}
+		derived[key.ID] = derivedKey{mac: derive(key.Secret, "authenticate"), aead: aead}
+	}
+
+	securityMtx.Lock()
+	defer securityMtx.Unlock()
+	if len(newKeys) == 0 {
+		keys, encrypt = nil, false
+		return nil
+	}
+	keys, sendKey, encrypt = derived, newKeys[0].ID, encryptPayload
+	return nil
+}
+
+// Returns the counts of packets opened and dropped on all connections
+func SecurityStatistics() SecurityStats {
+	securityMtx.RLock()
+	defer securityMtx.RUnlock()
+	return securityStats
+}
+
+// Returns the sender ID of this process
+func randomSenderID() uint64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return binary.BigEndian.Uint64(b[:])
+}
+
+// Returns a 32 byte key for the purpose, derived from the secret
+func derive(secret []byte, purpose string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(purpose))
+	return h.Sum(nil)
+}
+
+// Returns the packet sealed with the send key, or the packet as it is when no
+// keys are set
+func seal(packet []byte) ([]byte, error) {
+	securityMtx.RLock()
+	defer securityMtx.RUnlock()
+	if keys == nil {
+		return packet, nil
+	}
+	key := keys[sendKey]
+
+	var flags byte
+	if encrypt {
+		flags = flagEncrypted
+	}
+	header := append([]byte(secureMagic), flags, sendKey)
+	header = appendUint64(header, senderID)
+	header = appendUint64(header, atomic.AddUint64(&sendCounter, 1))
+	header = appendUint64(header, uint64(time.Now().UnixNano()))
+	if encrypt {
+		nonce := make([]byte, key.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		sealed := append(header, nonce...)
+		return key.aead.Seal(sealed, nonce, packet, header), nil
+	}
+	sealed := append(header, packet...)
+	mac := hmac.New(sha256.New, key.mac)
+	mac.Write(sealed)
+	return mac.Sum(sealed), nil
+}
+
+// Returns the payload of a sealed packet, and false if it must be dropped.
+// When no keys are set, packets that are not sealed are returned as they are
+func open(packet []byte) ([]byte, bool) {
+	securityMtx.Lock()
+	defer securityMtx.Unlock()
+	isSealed := len(packet) >= secureHeaderSize && string(packet[:len(secureMagic)]) == secureMagic
+	switch {
+	case keys == nil && !isSealed:
+		return packet, true
+	case keys == nil:
+		securityStats.NoKeys++
+		return nil, false
+	case !isSealed:
+		securityStats.Unauthenticated++
+		return nil, false
+	}
+
+	flags, id := packet[len(secureMagic)], packet[len(secureMagic)+1]
+	key, known := keys[id]
+	if !known {
+		securityStats.UnknownKey++
+		return nil, false
+	}
+
+	var payload []byte
+	if flags&flagEncrypted != 0 {
+		nonceSize := key.aead.NonceSize()
+		if len(packet) < secureHeaderSize+nonceSize+key.aead.Overhead() {
+			securityStats.BadTag++
+			return nil, false
+		}
+		nonce := packet[secureHeaderSize : secureHeaderSize+nonceSize]
+		var err error
+		payload, err = key.aead.Open(nil, nonce, packet[secureHeaderSize+nonceSize:], packet[:secureHeaderSize])
+		if err != nil {
+			securityStats.BadTag++
+			return nil, false
+		}
+	} else {
+		if len(packet) < secureHeaderSize+macSize {
+			securityStats.BadTag++
+			return nil, false
+		}
+		mac := hmac.New(sha256.New, key.mac)
+		mac.Write(packet[:len(packet)-macSize])
+		if !hmac.Equal(mac.Sum(nil), packet[len(packet)-macSize:]) {
+			securityStats.BadTag++
+			return nil, false
+		}
+		payload = packet[secureHeaderSize : len(packet)-macSize]
+	}
+	if !fresh(packet[len(secureMagic)+2:secureHeaderSize], time.Now()) {
+		return nil, false
+	}
+	securityStats.Accepted++
+	return payload, true
+}
+
+func appendUint64(b []byte, x uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], x)
+	return append(b, buf[:]...)
+}
+
+// Returns true if the sender, counter and time in the authenticated header are
+// from a packet that is not too old and has not been received before, and
+// records the counter. Must be called with securityMtx locked
+func fresh(header []byte, now time.Time) bool {
+	sender := binary.BigEndian.Uint64(header)
+	counter := binary.BigEndian.Uint64(header[8:])
+	sealedAt := time.Unix(0, int64(binary.BigEndian.Uint64(header[16:])))
+	if age := now.Sub(sealedAt); age > maxPacketAge || age < -maxPacketAge {
+		securityStats.Stale++
+		return false
+	}
+
+	counters, known := received[sender]
+	if !known {
+		forgetSenders(now)
+		counters = &senderCounters{seen: make(map[uint64]bool)}
+		received[sender] = counters
+	}
+	switch {
+	case counters.highest >= replayWindow && counter <= counters.highest-replayWindow:
+		securityStats.Stale++
+		return false
+	case counters.seen[counter]:
+		securityStats.Replayed++
+		return false
+	}
+	counters.seen[counter] = true
+	counters.lastSeen = now
+	if counter > counters.highest {
+		counters.highest = counter
+		if len(counters.seen) > 2*replayWindow {
+			for c := range counters.seen {
+				if counters.highest >= replayWindow && c <= counters.highest-replayWindow {
+					delete(counters.seen, c)
+				}
+			}
+		}
+	}
+	return true
+}
+
+// Forgets the senders that have sent nothing for longer than maxPacketAge, the
+// packets they sent are too old to be accepted anyway
+func forgetSenders(now time.Time) {
+	for sender, counters := range received {
+		if now.Sub(counters.lastSeen) > maxPacketAge {
+			delete(received, sender)
+		}
+	}
+}
+
+// A connection that seals every packet it sends and drops the packets it
+// receives that don't open
+type securePacketConn struct {
+	net.PacketConn
+	buf []byte
+}
+
+func secure(c net.PacketConn) net.PacketConn {
+	return &securePacketConn{PacketConn: c, buf: make([]byte, 65536)}
+}
+
+func (c *securePacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	sealed, err := seal(b)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := c.PacketConn.WriteTo(sealed, addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *securePacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(c.buf)
+		if err != nil {
+			return 0, addr, err
+		}
+		if payload, ok := open(c.buf[:n]); ok {
+			return copy(b, payload), addr, nil
+		}
+	}
+}
+
+// Makes room for packets that are not read yet, if the connection can
+func (c *securePacketConn) SetReadBuffer(bytes int) error {
+	if udp, ok := c.PacketConn.(interface{ SetReadBuffer(int) error }); ok {
+		return udp.SetReadBuffer(bytes)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package conn
+
+import (
+	"testing"
+	"time"
+)
+
+// A sealed packet is opened once, and dropped when it is sent again, both
+// authenticated and encrypted
+func TestOpenDropsReplayedPackets(t *testing.T) {
+	defer SetKeys(nil, false)
+	for _, encrypted := range []bool{false, true} {
+		if err := SetKeys([]Key{{ID: 1, Secret: []byte("secret")}}, encrypted); err != nil {
+			t.Fatal(err)
+		}
+		first, _ := seal([]byte("first"))
+		second, _ := seal([]byte("second"))
+		before := SecurityStatistics()
+
+		if payload, ok := open(second); !ok || string(payload) != "second" {
+			t.Errorf("encrypted %v: the second packet was not opened", encrypted)
+		}
+		if payload, ok := open(first); !ok || string(payload) != "first" {
+			t.Errorf("encrypted %v: the first packet was not opened after the second", encrypted)
+		}
+		if _, ok := open(first); ok {
+			t.Errorf("encrypted %v: the first packet was opened twice", encrypted)
+		}
+		if replayed := SecurityStatistics().Replayed - before.Replayed; replayed != 1 {
+			t.Errorf("encrypted %v: counted %d replayed packets, want 1", encrypted, replayed)
+		}
+	}
+}
+
+// A packet sealed longer than maxPacketAge ago, or too far behind the newest
+// one from the sender, is stale
+func TestOpenDropsStalePackets(t *testing.T) {
+	defer SetKeys(nil, false)
+	if err := SetKeys([]Key{{ID: 1, Secret: []byte("secret")}}, false); err != nil {
+		t.Fatal(err)
+	}
+	old, _ := seal([]byte("old"))
+	for i := 0; i < replayWindow; i++ {
+		packet, _ := seal([]byte("newer"))
+		if _, ok := open(packet); !ok {
+			t.Fatal("a new packet was not opened")
+		}
+	}
+	if _, ok := open(old); ok {
+		t.Error("a packet further behind than the replay window was opened")
+	}
+
+	late, _ := seal([]byte("late"))
+	securityMtx.Lock()
+	defer securityMtx.Unlock()
+	if fresh(late[len(secureMagic)+2:secureHeaderSize], time.Now().Add(2*maxPacketAge)) {
+		t.Error("a packet older than maxPacketAge was fresh")
+	}
+}
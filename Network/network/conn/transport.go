@@ -0,0 +1,133 @@
+package conn
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// The transports packets can be sent with. All peers on a network must use
+// the same one, set with SetTransport before any connection is made:
+//  broadcast  to 255.255.255.255, every host on the subnet gets the packets
+//  multicast  to an IPv4 multicast group, only the hosts that joined it get
+//             them, and managed networks that block broadcast often let them
+//             through
+//  unicast    to each peer in a static list of host:port entries, for
+//             networks where neither works. Every peer listens on its own port
+//             in the list, so several can run on one host, and the list must
+//             hold this peer as well, since every peer gets its own packets
+const (
+	Broadcast = "broadcast"
+	Multicast = "multicast"
+	Unicast   = "unicast"
+)
+
+var transportMtx sync.Mutex
+var transport = Broadcast
+var multicastGroup = net.IPv4(239, 255, 67, 89)
+var unicastPeers []*net.UDPAddr
+var unicastPort int
+
+// Sets the transport. group is the multicast group, peers the host:port of
+// every peer with unicast and port the one of this peer in that list, they are
+// only used by their transport. With unicast a peer gets the packets of its
+// first channel on its port and of the next ones on the ports after it, so the
+// ports of the peers on the same host must be far enough apart
+func SetTransport(name string, group string, peers []string, port int) error {
+	transportMtx.Lock()
+	defer transportMtx.Unlock()
+	switch name {
+	case Broadcast:
+	case Multicast:
+		ip := net.ParseIP(group)
+		if ip == nil || ip.To4() == nil || !ip.IsMulticast() {
+			return fmt.Errorf("%q is not an IPv4 multicast group (224.0.0.0 to 239.255.255.255)", group)
+		}
+		multicastGroup = ip
+	case Unicast:
+		if len(peers) == 0 {
+			return fmt.Errorf("unicast needs the host:port of every peer")
+		}
+		addrs := []*net.UDPAddr{}
+		listed := false
+		for _, peer := range peers {
+			peer = strings.TrimSpace(peer)
+			if _, _, err := net.SplitHostPort(peer); err != nil {
+				return fmt.Errorf("unicast peer %q is not host:port: %v", peer, err)
+			}
+			addr, err := net.ResolveUDPAddr("udp4", peer)
+			if err != nil {
+				return fmt.Errorf("can't resolve unicast peer %q: %v", peer, err)
+			}
+			if addr.Port == 0 {
+				return fmt.Errorf("unicast peer %q has no port", peer)
+			}
+			for _, other := range addrs {
+				if apart := other.Port - addr.Port; other.IP.Equal(addr.IP) && apart > -unicastChannels && apart < unicastChannels {
+					return fmt.Errorf("unicast peers %v and %v are less than %d ports apart", other, addr, unicastChannels)
+				}
+			}
+			listed = listed || addr.Port == port
+			addrs = append(addrs, addr)
+		}
+		if !listed {
+			return fmt.Errorf("the port of this peer, %d, is not in the unicast peers", port)
+		}
+		unicastPeers, unicastPort = addrs, port
+	default:
+		return fmt.Errorf("unknown transport %q, must be %s, %s or %s", name, Broadcast, Multicast, Unicast)
+	}
+	transport = name
+	return nil
+}
+
+// The number of channels that can be sent on with unicast, each gets its own
+// port counted from the port of the peer
+const unicastChannels = 2
+
+// Returns the port the channel (counted from 0) is received on. With unicast it
+// is the port of this peer plus the channel, otherwise the given port, which
+// all peers share
+func ChannelPort(channel int, port int) int {
+	transportMtx.Lock()
+	defer transportMtx.Unlock()
+	if transport == Unicast {
+		return unicastPort + channel
+	}
+	return port
+}
+
+// Returns a connection that receives the packets sent to `port` with the
+// transport, and can send them. With port 0 it is bound to a free port, which
+// transmitters use: a unicast packet only goes to one of the connections bound
+// to its port
+func Dial(port int) net.PacketConn {
+	transportMtx.Lock()
+	name, group := transport, multicastGroup
+	transportMtx.Unlock()
+	if name == Multicast {
+		return DialMulticastUDP(port, group)
+	}
+	return DialBroadcastUDP(port)
+}
+
+// Returns the addresses a packet to `port` must be sent to with the transport.
+// With unicast `port` is one returned by ChannelPort, and the packet goes to the
+// same channel of every peer
+func Destinations(port int) []net.Addr {
+	transportMtx.Lock()
+	defer transportMtx.Unlock()
+	switch transport {
+	case Multicast:
+		return []net.Addr{&net.UDPAddr{IP: multicastGroup, Port: port}}
+	case Unicast:
+		addrs := []net.Addr{}
+		for _, peer := range unicastPeers {
+			addrs = append(addrs, &net.UDPAddr{IP: peer.IP, Port: peer.Port + port - unicastPort})
+		}
+		return addrs
+	}
+	addr, _ := net.ResolveUDPAddr("udp4", fmt.Sprintf("255.255.255.255:%d", port))
+	return []net.Addr{addr}
+}
@@ -0,0 +1,47 @@
+package conn
+
+import "testing"
+
+// With unicast every peer, this one included, gets a packet on its own port
+// for the channel, so several peers can run on one host
+func TestUnicastPorts(t *testing.T) {
+	defer SetTransport(Broadcast, "", nil, 0)
+	peers := []string{"127.0.0.1:20000", "127.0.0.1:20010", "10.0.0.2:20000"}
+	if err := SetTransport(Unicast, "", peers, 20010); err != nil {
+		t.Fatal(err)
+	}
+
+	if port := ChannelPort(1, 16789); port != 20011 {
+		t.Errorf("channel 1 is received on %d, want 20011", port)
+	}
+	want := []string{"127.0.0.1:20001", "127.0.0.1:20011", "10.0.0.2:20001"}
+	got := Destinations(ChannelPort(1, 16789))
+	if len(got) != len(want) {
+		t.Fatalf("sent to %v, want %v", got, want)
+	}
+	for i, addr := range got {
+		if addr.String() != want[i] {
+			t.Errorf("sent to %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestUnicastRejectsBadPeers(t *testing.T) {
+	defer SetTransport(Broadcast, "", nil, 0)
+	for _, bad := range []struct {
+		peers []string
+		port  int
+	}{
+		{[]string{"127.0.0.1"}, 20000},                          //No port
+		{[]string{"127.0.0.1:20000", "127.0.0.1:20001"}, 20000}, //The channels overlap
+		{[]string{"127.0.0.1:20000"}, 20010},                    //This peer is not listed
+	} {
+		if err := SetTransport(Unicast, "", bad.peers, bad.port); err == nil {
+			t.Errorf("accepted %v with port %d", bad.peers, bad.port)
+		}
+	}
+	if ChannelPort(0, 15432) != 15432 {
+		t.Errorf("a rejected unicast transport is in use")
+	}
+}
@@ -1,8 +1,6 @@
 package peers
 
 import (
-	"fmt"
-	"net"
 	"sort"
 	"time"
 
@@ -20,8 +18,8 @@ const timeout = 2000 * time.Millisecond
 
 func Transmitter(port int, id string, transmitEnable <-chan bool) {
 
-	conn := conn.DialBroadcastUDP(port)
-	addr, _ := net.ResolveUDPAddr("udp4", fmt.Sprintf("255.255.255.255:%d", port))
+	addrs := conn.Destinations(port)
+	conn := conn.Dial(0) // Sends from a free port, so with unicast only the receivers get the packets to `port`
 
 	enable := true
 	for {
@@ -30,7 +28,9 @@ func Transmitter(port int, id string, transmitEnable <-chan bool) {
 		case <-time.After(interval):
 		}
 		if enable {
-			conn.WriteTo([]byte(id), addr)
+			for _, addr := range addrs {
+				conn.WriteTo([]byte(id), addr)
+			}
 		}
 	}
 }
@@ -41,7 +41,7 @@ func Receiver(port int, peerUpdateCh chan<- PeerUpdate) {
 	var p PeerUpdate
 	lastSeen := make(map[string]time.Time)
 
-	conn := conn.DialBroadcastUDP(port)
+	conn := conn.Dial(port)
 
 	for {
 		updated := false
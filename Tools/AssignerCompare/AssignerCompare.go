@@ -0,0 +1,172 @@
+package main
+
+/* AssignerCompare checks the Go HallRequestAssigner against the hall_request_assigner executable. The golden file
+holds states together with the assignments the executable calculated for them, and every case in it is checked
+against the Go version. With -RECORD, new random cases are run through the executable and written to the golden
+file. With -LIVE, random cases are run through both and compared directly.
+Example: go run Tools/AssignerCompare/AssignerCompare.go -LIVE -CASES=10000
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+
+	"../../ElevState"
+	"../../HallRequestAssigner"
+)
+
+//Type of one case in the golden file
+type goldenCase struct {
+	States      ElevState.AllStates  `json:"states"`
+	Assignments map[string][][2]bool `json:"assignments"`
+}
+
+func main() {
+	var binary, golden string
+	var cases int
+	var seed int64
+	var record, live bool
+	flag.StringVar(&binary, "BINARY", "./hall_request_assigner", "The hall_request_assigner executable")
+	flag.StringVar(&golden, "GOLDEN", "HallRequestAssigner/testdata/golden.json", "The golden file")
+	flag.IntVar(&cases, "CASES", 200, "The number of random cases to record or compare")
+	flag.Int64Var(&seed, "SEED", 1, "The seed of the random cases")
+	flag.BoolVar(&record, "RECORD", false, "Run random cases through the executable and write them to the golden file")
+	flag.BoolVar(&live, "LIVE", false, "Compare random cases with the executable instead of the golden file")
+	flag.Parse()
+
+	random := rand.New(rand.NewSource(seed))
+
+	switch {
+	case record:
+		recorded := []goldenCase{}
+		for i := 0; i < cases; i++ {
+			states := randomStates(random)
+			assignments, err := runBinary(binary, states)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error running", binary+":", err)
+				os.Exit(1)
+			}
+			recorded = append(recorded, goldenCase{States: states, Assignments: assignments})
+		}
+		lines := []string{}
+		for _, c := range recorded { //One case on each line, so the file is easy to search and diff
+			line, _ := json.Marshal(c)
+			lines = append(lines, string(line))
+		}
+		data := "[\n" + strings.Join(lines, ",\n") + "\n]\n"
+		if err := os.WriteFile(golden, []byte(data), 0644); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing golden file:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Recorded", len(recorded), "cases in", golden)
+
+	case live:
+		failed := 0
+		for i := 0; i < cases; i++ {
+			states := randomStates(random)
+			expected, err := runBinary(binary, states)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error running", binary+":", err)
+				os.Exit(1)
+			}
+			if !check(i, states, expected) {
+				failed++
+			}
+		}
+		report(cases, failed)
+
+	default:
+		data, err := os.ReadFile(golden)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading golden file:", err)
+			os.Exit(1)
+		}
+		recorded := []goldenCase{}
+		if err := json.Unmarshal(data, &recorded); err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading golden file:", err)
+			os.Exit(1)
+		}
+		failed := 0
+		for i, c := range recorded {
+			if !check(i, c.States, c.Assignments) {
+				failed++
+			}
+		}
+		report(len(recorded), failed)
+	}
+}
+
+//Runs the Go version on states and prints the case if it doesn't give the expected assignments
+func check(i int, states ElevState.AllStates, expected map[string][][2]bool) bool {
+	assignments, err := HallRequestAssigner.Assign(states)
+	if err == nil && reflect.DeepEqual(assignments, expected) {
+		return true
+	}
+	input, _ := json.Marshal(states)
+	fmt.Printf("Case %d: %s\n  expected %v\n  got      %v (error: %v)\n", i, input, expected, assignments, err)
+	return false
+}
+
+//Prints how many cases failed, and exits with an error if any did
+func report(cases int, failed int) {
+	fmt.Printf("%d of %d cases match\n", cases-failed, cases)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+//Runs the executable on states the same way DistributeOrders used to
+func runBinary(binary string, states ElevState.AllStates) (map[string][][2]bool, error) {
+	input, err := json.Marshal(states)
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(binary, "-i", string(input))
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%v: %s", err, stderr.String())
+	}
+	assignments := make(map[string][][2]bool)
+	err = json.Unmarshal(stdout.Bytes(), &assignments)
+	return assignments, err
+}
+
+//Makes random states for 2 to 8 floors and 1 to 4 elevators. Moving elevators are never moving out of the building
+func randomStates(random *rand.Rand) ElevState.AllStates {
+	floors := 2 + random.Intn(7)
+	states := ElevState.AllStates{HallRequests: make([][2]bool, floors), States: make(map[string]ElevState.SingleStates)}
+	for floor := range states.HallRequests {
+		states.HallRequests[floor] = [2]bool{floor < floors-1 && random.Intn(3) == 0, floor > 0 && random.Intn(3) == 0}
+	}
+
+	elevators := 1 + random.Intn(4)
+	for len(states.States) < elevators {
+		state := ElevState.SingleStates{Floor: random.Intn(floors), CabRequests: make([]bool, floors)}
+		for floor := range state.CabRequests {
+			state.CabRequests[floor] = random.Intn(4) == 0
+		}
+		state.Behavior = []string{"idle", "moving", "doorOpen"}[random.Intn(3)]
+		state.Direction = []string{"up", "down", "stop"}[random.Intn(3)]
+		if state.Behavior == "moving" {
+			switch {
+			case state.Floor == 0:
+				state.Direction = "up"
+			case state.Floor == floors-1:
+				state.Direction = "down"
+			case state.Direction == "stop":
+				state.Direction = []string{"up", "down"}[random.Intn(2)]
+			}
+		}
+		states.States[fmt.Sprintf("peer-%d", random.Intn(100))] = state
+	}
+	return states
+}
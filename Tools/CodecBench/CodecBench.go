@@ -0,0 +1,110 @@
+package main
+
+/* CodecBench compares the codecs bcast can send NetworkMessages with (see Network/network/bcast/codec.go). For a
+message like the ones the elevators send, with the given number of floors and peers, it measures the time to encode
+and decode it, the bytes on the wire, and the bandwidth the whole network uses with every elevator sending every
+100 ms. It also checks that every codec gives back the message it was given, and fails if one doesn't.
+Example: go run Tools/CodecBench/CodecBench.go -FLOORS=4 -PEERS=3
+*/
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"../../ElevState"
+	"../../Network/network/bcast"
+)
+
+const sendInterval = 100 * time.Millisecond //How often every elevator sends its NetworkMessage, see Network.go
+
+func main() {
+	var floors, peers int
+	flag.IntVar(&floors, "FLOORS", 4, "The number of floors")
+	flag.IntVar(&peers, "PEERS", 3, "The number of elevators on the network")
+	flag.Parse()
+
+	message := sampleMessage(floors, peers)
+	fmt.Printf("NetworkMessage with %d floors and %d peers\n", floors, peers)
+	fmt.Printf("%-8s %10s %10s %8s %14s  %s\n", "codec", "encode", "decode", "bytes", "network", "round trip")
+	failed := false
+	for _, name := range bcast.CodecNames() {
+		encoded, err := bcast.EncodeMessage(name, message)
+		if err != nil {
+			fmt.Printf("%-8s failed to encode: %v\n", name, err)
+			failed = true
+			continue
+		}
+		var decoded ElevState.NetworkMessage
+		roundTrip := "ok"
+		if err := bcast.DecodeMessage(encoded, &decoded); err != nil {
+			roundTrip = "FAILED: " + err.Error()
+			failed = true
+		} else if !reflect.DeepEqual(decoded, message) {
+			roundTrip = fmt.Sprintf("FAILED: got %+v", decoded)
+			failed = true
+		}
+
+		encode := testing.Benchmark(func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				bcast.EncodeMessage(name, message)
+			}
+		})
+		decode := testing.Benchmark(func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				var m ElevState.NetworkMessage
+				bcast.DecodeMessage(encoded, &m)
+			}
+		})
+		perSecond := len(encoded) * peers * int(time.Second/sendInterval)
+		fmt.Printf("%-8s %10v %10v %8d %12.1fkB/s  %s\n", name, time.Duration(encode.NsPerOp()), time.Duration(decode.NsPerOp()), len(encoded), float64(perSecond)/1000, roundTrip)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+//Returns a NetworkMessage with every field filled in, the way a running elevator sends it
+func sampleMessage(floors int, peers int) ElevState.NetworkMessage {
+	message := ElevState.NetworkMessage{
+		ID:           "peer-10.0.0.1",
+		MessageType:  "StateUpdate",
+		HallRequests: make([][2]bool, floors),
+		HallOrders:   make([][2]ElevState.HallOrderState, floors),
+		HallVersions: make([][2]uint64, floors),
+		CabBackups:   make(map[string][]bool),
+		Floors:       floors,
+		Epoch:        time.Now().UnixNano(),
+		Seq:          123456,
+		Policy:       ElevState.DispatchPolicy{Name: "timeToServe", Stamp: time.Now().UnixNano(), SetBy: "peer-10.0.0.1"},
+		Assignment:   ElevState.AssignmentDigest{InputHash: "0123456789abcdef", Owners: make([]string, 2*floors)},
+		Codecs:       bcast.AdvertisedCodecs(ElevState.NetworkMessage{}),
+		Events:       []ElevState.CriticalEvent{{Critical: 12, MessageType: "ClearOrder", Floor: 1, ClearOrderDirection: "up"}},
+		Acks:         []ElevState.CriticalAck{{PeerID: "peer-10.0.0.2", Epoch: time.Now().UnixNano(), Critical: 7}},
+		RemoteState: ElevState.SingleStates{
+			Behavior:     "moving",
+			Floor:        floors / 2,
+			Direction:    "up",
+			CabRequests:  make([]bool, floors),
+			Availability: ElevState.AV_Available,
+			TravelTime:   ElevState.DefaultTravelTime,
+			DoorTime:     ElevState.DefaultDoorTime,
+		},
+	}
+	for floor := 0; floor < floors; floor++ {
+		if floor%2 == 0 {
+			message.HallRequests[floor][0] = true
+			message.HallOrders[floor][0] = ElevState.HO_Confirmed
+			message.HallVersions[floor][0] = uint64(10 + floor)
+			message.Assignment.Owners[2*floor] = fmt.Sprintf("peer-10.0.0.%d", 1+floor%peers)
+		}
+		message.RemoteState.CabRequests[floor] = floor%3 == 0
+	}
+	for p := 2; p <= peers; p++ {
+		message.CabBackups[fmt.Sprintf("peer-10.0.0.%d", p)] = make([]bool, floors)
+	}
+	return message
+}
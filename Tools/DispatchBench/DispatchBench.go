@@ -0,0 +1,330 @@
+package main
+
+/* DispatchBench runs a traffic scenario through the assigners and the FSM logic on simulated cars (see
+FSM/Simulated.go) in simulated time, and reports the wait times (from the call to boarding) and journey times (from
+the call to arriving) of the passengers, so assigners and hysteresis settings can be compared on the same traffic. The scenario file has one passenger on each line: the
+time of the call in seconds, the floor the passenger calls from and the floor the passenger goes to. Lines starting
+with # are comments. The cars start idle at floor 0, and hall calls are confirmed at once, as if the network was
+perfect. The simulation is deterministic, so the same scenario always gives the same numbers.
+Example: go run Tools/DispatchBench/DispatchBench.go -SCENARIO=Tools/DispatchBench/example.txt -CARS=3 -ASSIGNER=all
+Cars with different speeds are given a time for each car, like -TRAVEL=1.5s,2.5s,4s -DOOR=3s, which they send in
+their state the way the elevators do (see ElevState/CarParameters.go).
+With -GENERATE=<n> a random scenario of n passengers is written to the scenario file instead.
+*/
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"../../DistributeOrders"
+	"../../ElevState"
+	"../../FSM"
+)
+
+const step = 100 * time.Millisecond //The time between two steps of the simulation
+
+//Type of a passenger in the scenario
+type passenger struct {
+	call        time.Duration
+	origin      int
+	destination int
+	boarded     time.Duration //Zero until the passenger has boarded
+	arrived     time.Duration //Zero until the passenger has arrived
+	car         int
+}
+
+//Type of the result of a run
+type result struct {
+	waits, journeys []time.Duration
+	unserved        int
+}
+
+var floors int
+var travelTimes, doorTimes []time.Duration //The times of every car, the last one is used for the cars after it
+
+func main() {
+	var scenario, assignerName, travel, door string
+	var cars, generate int
+	var seed int64
+	var hysteresis time.Duration
+	flag.StringVar(&scenario, "SCENARIO", "Tools/DispatchBench/example.txt", "The scenario file")
+	flag.IntVar(&cars, "CARS", 3, "The number of cars")
+	flag.IntVar(&floors, "FLOORS", 4, "The number of floors")
+	flag.StringVar(&assignerName, "ASSIGNER", "all", "The assigner to run, or all to compare them: "+strings.Join(DistributeOrders.AssignerNames(), ", "))
+	flag.DurationVar(&hysteresis, "HYSTERESIS", DistributeOrders.Hysteresis, "The hysteresis threshold, 0 turns it off")
+	flag.StringVar(&travel, "TRAVEL", "2.5s", "The time to travel between two floors, or a time for every car separated by commas")
+	flag.StringVar(&door, "DOOR", "3s", "The time the door is open, or a time for every car separated by commas")
+	flag.IntVar(&generate, "GENERATE", 0, "Write a random scenario with this many passengers to the scenario file instead of running it")
+	flag.Int64Var(&seed, "SEED", 1, "The seed of the random scenario")
+	flag.Parse()
+	FSM.NFLOORS = floors
+
+	var err error
+	if travelTimes, err = parseDurations(travel); err != nil {
+		fmt.Fprintln(os.Stderr, "Error in -TRAVEL:", err)
+		os.Exit(1)
+	}
+	if doorTimes, err = parseDurations(door); err != nil {
+		fmt.Fprintln(os.Stderr, "Error in -DOOR:", err)
+		os.Exit(1)
+	}
+
+	if generate > 0 {
+		if err := writeScenario(scenario, generate, rand.New(rand.NewSource(seed))); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing scenario:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Wrote", generate, "passengers to", scenario)
+		return
+	}
+
+	passengers, err := readScenario(scenario)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading scenario:", err)
+		os.Exit(1)
+	}
+
+	names := []string{assignerName}
+	if assignerName == "all" {
+		names = DistributeOrders.AssignerNames()
+	}
+	fmt.Printf("%d passengers, %d cars, %d floors, hysteresis %v, travel %s, door %s\n", len(passengers), cars, floors, hysteresis, travel, door)
+	fmt.Printf("%-12s %10s %10s %10s %10s %9s\n", "assigner", "avg wait", "p95 wait", "avg trip", "p95 trip", "unserved")
+	for _, name := range names {
+		assigner, ok := DistributeOrders.LookupAssigner(name)
+		if !ok {
+			fmt.Fprintln(os.Stderr, "Unknown assigner", name)
+			os.Exit(1)
+		}
+		r, err := run(assigner, passengers, cars, hysteresis)
+		if err != nil {
+			fmt.Printf("%-12s skipped: %v\n", name, err)
+			continue
+		}
+		fmt.Printf("%-12s %10v %10v %10v %10v %9d\n", name, average(r.waits), percentile(r.waits, 95), average(r.journeys), percentile(r.journeys, 95), r.unserved)
+	}
+}
+
+//Runs the scenario with the assigner until every passenger has arrived, or until an hour after the last call
+func run(assigner DistributeOrders.Assigner, scenario []passenger, cars int, hysteresis time.Duration) (result, error) {
+	passengers := append([]passenger{}, scenario...)
+	elevators := make([]FSM.SimulatedCar, cars)
+	for c := range elevators {
+		elevators[c].State = ElevState.SingleStates{Behavior: "idle", Floor: 0, Direction: "stop", CabRequests: make([]bool, floors)}
+		elevators[c].State.TravelTime = travelTimes[min(c, len(travelTimes)-1)]
+		elevators[c].State.DoorTime = doorTimes[min(c, len(doorTimes)-1)]
+	}
+	hall := ElevState.AllStates{HallRequests: make([][2]bool, floors), HallVersions: make([][2]uint64, floors)}
+	version := uint64(0)
+	var previous map[string][][2]bool
+	delivered := make([]DistributeOrders.OrderUpdate, cars) //The last orders sent to every car
+
+	end := time.Hour
+	if len(passengers) > 0 {
+		end += passengers[len(passengers)-1].call
+	}
+	next := 0 //The next passenger to call
+	for now := time.Duration(0); now < end; now += step {
+		//The passengers whose time has come press the hall button
+		for ; next < len(passengers) && passengers[next].call <= now; next++ {
+			p := passengers[next]
+			button := buttonFor(p)
+			if !hall.HallRequests[p.origin][button] {
+				version++
+				hall.HallRequests[p.origin][button] = true
+				hall.HallVersions[p.origin][button] = version
+			}
+		}
+		if next == len(passengers) && allArrived(passengers) {
+			break
+		}
+
+		//Every car gets its orders, the same way DistributeOrders sends them to the FSM
+		states := hall
+		states.States = make(map[string]ElevState.SingleStates)
+		for c := range elevators {
+			states.States[carID(c)] = elevators[c].State
+		}
+		assignment, err := assigner.Assign(states)
+		if err != nil {
+			return result{}, err
+		}
+		assignment, _ = DistributeOrders.Stabilize(previous, assignment, states, hysteresis)
+		previous = assignment
+
+		for c := range elevators { //Like DistributeOrders, the orders are only sent when something has changed
+			orders := DistributeOrders.OrderUpdate{DistributedOrders: assignment[carID(c)], State: elevators[c].State}
+			if !reflect.DeepEqual(orders, delivered[c]) {
+				delivered[c] = orders
+				event, _ := elevators[c].OnOrders(orders)
+				onEvent(&elevators[c], c, event, &hall, passengers, now)
+			}
+		}
+		for c := range elevators {
+			orders := DistributeOrders.OrderUpdate{DistributedOrders: assignment[carID(c)], State: elevators[c].State}
+			event, _ := elevators[c].Step(step, orders)
+			onEvent(&elevators[c], c, event, &hall, passengers, now)
+		}
+	}
+
+	r := result{}
+	for _, p := range passengers {
+		if p.arrived == 0 {
+			r.unserved++
+			continue
+		}
+		r.waits = append(r.waits, p.boarded-p.call)
+		r.journeys = append(r.journeys, p.arrived-p.call)
+	}
+	return r, nil
+}
+
+//Serves the floor when the car opens its door there, like ElevState does when the FSM sends a ClearOrder
+func onEvent(e *FSM.SimulatedCar, c int, event ElevState.EventMessage, hall *ElevState.AllStates, passengers []passenger, now time.Duration) {
+	if event.EventType == "ClearOrder" {
+		serve(e, c, event.ClearOrderDirection, hall, passengers, now)
+	}
+}
+
+//Opens the door at the car's floor: the passengers going there get off, and the ones waiting for the cleared hall
+//call get on and press the cab button for their floor
+func serve(e *FSM.SimulatedCar, c int, clear string, hall *ElevState.AllStates, passengers []passenger, now time.Duration) {
+	floor := e.State.Floor
+	e.State.CabRequests = append([]bool{}, e.State.CabRequests...)
+	e.State.CabRequests[floor] = false
+	for i := range passengers {
+		p := &passengers[i]
+		if p.boarded != 0 && p.arrived == 0 && p.car == c && p.destination == floor {
+			p.arrived = now
+		}
+	}
+	if clear == "noHall" {
+		return
+	}
+	button := map[string]int{"up": 0, "down": 1}[clear]
+	hall.HallRequests = append([][2]bool{}, hall.HallRequests...)
+	hall.HallRequests[floor][button] = false
+	for i := range passengers {
+		p := &passengers[i]
+		if p.call <= now && p.boarded == 0 && p.origin == floor && buttonFor(*p) == button {
+			p.boarded, p.car = now, c
+			if p.boarded == 0 { //Boarding at time zero would look like not boarded
+				p.boarded = 1
+			}
+			e.State.CabRequests[p.destination] = true
+		}
+	}
+}
+
+func allArrived(passengers []passenger) bool {
+	for _, p := range passengers {
+		if p.arrived == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+//Returns the hall button the passenger presses, 0 for up and 1 for down
+func buttonFor(p passenger) int {
+	if p.destination > p.origin {
+		return 0
+	}
+	return 1
+}
+
+func carID(c int) string {
+	return fmt.Sprintf("car-%d", c+1)
+}
+
+//Reads the passengers from the scenario file, sorted by the time of the call
+func readScenario(path string) ([]passenger, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	passengers := []passenger{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var seconds float64
+		var p passenger
+		if _, err := fmt.Sscan(text, &seconds, &p.origin, &p.destination); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if p.origin < 0 || p.origin >= floors || p.destination < 0 || p.destination >= floors || p.origin == p.destination {
+			return nil, fmt.Errorf("line %d: can't go from floor %d to %d with %d floors", line, p.origin, p.destination, floors)
+		}
+		p.call = time.Duration(seconds * float64(time.Second))
+		passengers = append(passengers, p)
+	}
+	sort.SliceStable(passengers, func(i, j int) bool { return passengers[i].call < passengers[j].call })
+	return passengers, scanner.Err()
+}
+
+//Writes a random scenario with a passenger every 10 seconds on average
+func writeScenario(path string, count int, random *rand.Rand) error {
+	lines := []string{"# time (s), from floor, to floor"}
+	seconds := 0.0
+	for i := 0; i < count; i++ {
+		seconds += random.ExpFloat64() * 10
+		origin := random.Intn(floors)
+		destination := (origin + 1 + random.Intn(floors-1)) % floors
+		lines = append(lines, fmt.Sprintf("%.1f %d %d", seconds, origin, destination))
+	}
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+//Returns the durations in the comma separated list
+func parseDurations(list string) ([]time.Duration, error) {
+	durations := []time.Duration{}
+	for _, field := range strings.Split(list, ",") {
+		duration, err := time.ParseDuration(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		if duration <= 0 {
+			return nil, fmt.Errorf("%v is not a positive time", duration)
+		}
+		durations = append(durations, duration)
+	}
+	return durations, nil
+}
+
+func average(durations []time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	total := time.Duration(0)
+	for _, d := range durations {
+		total += d
+	}
+	return (total / time.Duration(len(durations))).Round(100 * time.Millisecond)
+}
+
+//Returns the duration that the given percent of the durations are shorter than or equal to
+func percentile(durations []time.Duration, percent float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration{}, durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	index := int(math.Ceil(percent/100*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	return sorted[index].Round(100 * time.Millisecond)
+}
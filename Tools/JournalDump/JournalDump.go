@@ -0,0 +1,66 @@
+package main
+
+/* JournalDump prints the entries in an elevator's journal, for finding out what happened before an incident.
+Run it from the directory the elevator was run from, with the same -ID (and -BACKUP if it was used), or give the
+journal file directly with -FILE. The journal from before the last compaction, in the ".1" file, is printed first.
+Example: go run Tools/JournalDump/JournalDump.go -ID=peer-10.0.3.15
+*/
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"../../ElevState"
+)
+
+var buttonNames = []string{"hallUp", "hallDown", "cab"}
+
+func main() {
+	var id, backup, file string
+	var asJSON bool
+	flag.StringVar(&id, "ID", "", "The ID of the elevator that wrote the journal")
+	flag.StringVar(&backup, "BACKUP", "", "The backup file the elevator was run with")
+	flag.StringVar(&file, "FILE", "", "The journal file to dump, overrides -ID and -BACKUP")
+	flag.BoolVar(&asJSON, "JSON", false, "Print the entries as JSON lines instead of a table")
+	flag.Parse()
+
+	if file == "" {
+		if id == "" && backup == "" {
+			fmt.Fprintln(os.Stderr, "Give the journal with -FILE, or the elevator with -ID")
+			os.Exit(2)
+		}
+		ElevState.ID = id
+		ElevState.BackupPath = backup
+		file = ElevState.JournalFile()
+	}
+
+	entries, err := ElevState.ReadJournal(file + ".1")
+	if os.IsNotExist(err) {
+		entries, err = []ElevState.JournalEntry{}, nil
+	}
+	current, currentErr := ElevState.ReadJournal(file)
+	entries = append(entries, current...)
+	if currentErr != nil {
+		err = currentErr
+	}
+	for _, entry := range entries {
+		if asJSON {
+			line, _ := json.Marshal(entry)
+			fmt.Println(string(line))
+			continue
+		}
+		button := fmt.Sprint(entry.Button)
+		if int(entry.Button) >= 0 && int(entry.Button) < len(buttonNames) {
+			button = buttonNames[entry.Button]
+		}
+		fmt.Printf("%6d  %s  %-13s  source=%s peer=%s floor=%d button=%s\n",
+			entry.Seq, entry.Time.Format("2006-01-02 15:04:05.000"), entry.Event, entry.Source, entry.PeerID, entry.Floor, button)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading journal:", err)
+		os.Exit(1)
+	}
+	fmt.Fprintln(os.Stderr, len(entries), "entries in", file)
+}
@@ -10,12 +10,16 @@ When receiving the update from an elevator's Network module, the receiving Netwo
 to their ElevState modules which stores them.*/
 
 import (
+	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"os/signal"
+	"strconv"
+	"strings"
 	"time"
 
 	"./DistributeOrders"
@@ -24,24 +28,110 @@ import (
 
 	"./FSM"
 	"./Network"
+	"./Network/network/bcast"
+	"./Network/network/conn"
 	"./Network/network/localip"
 	"./Network/network/peers"
 
 	"./ElevState"
 )
 
-const NFLOORS = 4 // number of floors
-
-var ID string   //Peer ID (IP address)
-var PORT string //IP address PORT number
+var NFLOORS int      //number of floors
+var ID string        //Peer ID (IP address)
+var PORT string      //IP address PORT number
+var BACKUP string    //Path of the state backup file
+var EVENTLOG bool    //Print every event from the ElevState event bus
+var ASSIGNER string  //Name of the assigner used until it is switched, see DistributeOrders/Assigner.go
+var SERVES string    //The floors this elevator serves, see ElevState/CarParameters.go
+var TRANSPORT string //How packets are sent to the peers, see Network/network/conn/transport.go
+var GROUP string     //The multicast group used with the multicast transport
+var UNICAST string   //The host:port of every elevator with the unicast transport, separated by commas
+var UNICASTPORT int  //The port of this elevator in UNICAST
 
 func main() {
 
 	//Flags used to set the ID and PORT
 	flag.StringVar(&ID, "ID", "", "The ID of this peer")                          	  //OPTIONAL: give a custom ID and/or port arguments when running. Example: run go main.go -ID=123 -PORT=456
 	flag.StringVar(&PORT, "PORT", "15657", "The PORT used in connection with server") //if no arguments are given the program runs with the values given in the code
+	flag.StringVar(&BACKUP, "BACKUP", "", "The file used to back up the states, defaults to elevator_states_<ID>.txt")
+	flag.IntVar(&NFLOORS, "FLOORS", 4, "The number of floors, must be the same for all peers")
+	flag.BoolVar(&EVENTLOG, "EVENTLOG", false, "Print every event: calls registered, assigned and served, cars moving, peers and faults")
+	flag.StringVar(&ASSIGNER, "ASSIGNER", "timeToServe", "The assigner used for hall requests, one of: "+strings.Join(DistributeOrders.AssignerNames(), ", "))
+	flag.StringVar(&DistributeOrders.ExternalPath, "EXTERNAL", DistributeOrders.ExternalPath, "The hall_request_assigner executable used by the external assigner")
+	flag.BoolVar(&DistributeOrders.LogExplanations, "EXPLAIN", false, "Log why a hall call went to a car every time it gets a new car")
+	flag.DurationVar(&DistributeOrders.Hysteresis, "HYSTERESIS", DistributeOrders.Hysteresis, "How much sooner another car must get to a call to take it from a car on its way there, 0 turns it off")
+	flag.StringVar(&DistributeOrders.DispatchMode, "DISPATCH", DistributeOrders.DM_Distributed, "distributed: every elevator assigns, master: only the elevator with the lowest ID assigns")
+	flag.DurationVar(&ElevState.CarTravelTime, "TRAVELTIME", ElevState.DefaultTravelTime, "The time this car needs to travel between two floors")
+	flag.DurationVar(&ElevState.CarDoorTime, "DOORTIME", ElevState.DefaultDoorTime, "The time this car has its door open at a floor")
+	flag.StringVar(&Network.PreferredCodec, "CODEC", Network.PreferredCodec, "The encoding of NetworkMessages once all peers can decode it, one of: "+strings.Join(bcast.CodecNames(), ", "))
+	flag.StringVar(&Network.KeyFile, "KEYFILE", "", "The file with the pre-shared keys that authenticate the traffic between elevators, none if empty")
+	flag.BoolVar(&Network.Encrypt, "ENCRYPT", false, "Encrypt the traffic between elevators as well, needs -KEYFILE")
+	flag.StringVar(&TRANSPORT, "TRANSPORT", conn.Broadcast, "How packets are sent to the peers: broadcast, multicast or unicast, must be the same for all peers")
+	flag.StringVar(&GROUP, "GROUP", "239.255.67.89", "The IPv4 multicast group used with -TRANSPORT=multicast")
+	flag.StringVar(&UNICAST, "UNICAST", "", "The host:port of every elevator including this one separated by commas, used with -TRANSPORT=unicast. Example: -UNICAST=10.0.0.2:20000,127.0.0.1:20010,127.0.0.1:20020")
+	flag.IntVar(&UNICASTPORT, "UNICASTPORT", 0, "The port of this elevator in -UNICAST, it gets packets on it and the port after it")
+	flag.StringVar(&Network.FaultRules, "FAULTS", "", "Faults injected into the packets from the peers, see Network/Faults.go, -FAULTS=* turns fault injection on without faults. Example: -FAULTS=\"* drop=0.4 delay=20ms jitter=10ms\"")
+	flag.StringVar(&SERVES, "SERVES", "", "The floors this car serves separated by commas, every floor if empty. Example: -SERVES=0,2,3")
 	flag.Parse()
 
+	if DistributeOrders.DispatchMode != DistributeOrders.DM_Distributed && DistributeOrders.DispatchMode != DistributeOrders.DM_Master {
+		fmt.Println("Unknown dispatch mode", DistributeOrders.DispatchMode+", must be distributed or master")
+		os.Exit(1)
+	}
+
+	if err := bcast.CheckCodec(Network.PreferredCodec); err != nil { //Only checks the name, Network chooses the codec to use
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	unicastPeers := []string{}
+	if UNICAST != "" {
+		unicastPeers = strings.Split(UNICAST, ",")
+	}
+	if err := conn.SetTransport(TRANSPORT, GROUP, unicastPeers, UNICASTPORT); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if Network.FaultRules != "" { //Faults can only be injected, and the packets are only tagged, when -FAULTS is given
+		conn.EnableFaults()
+	}
+	if err := Network.ApplyFaults(Network.FaultRules); err != nil {
+		fmt.Println("Error in -FAULTS:", err)
+		os.Exit(1)
+	}
+
+	if Network.Encrypt && Network.KeyFile == "" {
+		fmt.Println("-ENCRYPT needs the keys in -KEYFILE")
+		os.Exit(1)
+	}
+	if err := Network.LoadKeys(); err != nil {
+		fmt.Println("Error loading keys:", err)
+		os.Exit(1)
+	}
+
+	if _, ok := DistributeOrders.LookupAssigner(ASSIGNER); !ok {
+		fmt.Println("Unknown assigner", ASSIGNER+", must be one of:", strings.Join(DistributeOrders.AssignerNames(), ", "))
+		os.Exit(1)
+	}
+
+	if NFLOORS < 2 { //An elevator needs at least two floors to go between
+		fmt.Println("The number of floors must be at least 2, got", NFLOORS)
+		os.Exit(1)
+	}
+
+	if ElevState.CarTravelTime <= 0 || ElevState.CarDoorTime <= 0 {
+		fmt.Println("The travel time and door time must be positive")
+		os.Exit(1)
+	}
+
+	servedFloors, err := parseServedFloors(SERVES, NFLOORS)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	ElevState.CarServedFloors = servedFloors
+
 	if ID == "" { //checks if the ID is empty and if it is assigns the localIP and process ID to it
 		localIP, err := localip.LocalIP()
 		if err != nil {
@@ -70,6 +160,10 @@ func main() {
 	go ElevState.UpdateOrders(UpdatedAllStates, MsgToNetwork)
 
 	go restartProgram()
+	if EVENTLOG {
+		go logEvents()
+	}
+	go console(FSMEventMsg)
 
 	go Network.Network(PeerState, UpdatedPeers, MsgToNetwork, ID)
 	go FSM.FSM(CalculatedHallOrders, FSMEventMsg)
@@ -96,8 +190,11 @@ func restartProgram() {
 	signal.Notify(sigchan, os.Interrupt)
 	<-sigchan
 	elevio.SetMotorDirection(elevio.MD_Stop)
-	log.Println("Restarting", "sh", "-c", "go run main.go")                         		 //Setting PORT and ID variables
-	err := exec.Command("gnome-terminal", "-x", "sh", "-c", "go run main.go").Run() //Execute the command
+	//Restarts with the same flags, like PORT, ID and FLOORS. They are passed on as they are, not through a shell, so
+	//flag values with spaces or quotes stay the same
+	restartArgs := append([]string{"-x", "go", "run", "main.go"}, os.Args[1:]...)
+	log.Printf("Restarting gnome-terminal %q\n", restartArgs)
+	err := exec.Command("gnome-terminal", restartArgs...).Run() //Execute the command
 	if err != nil { //Print error if the restart fails
 		fmt.Println("Unable to restart")
 	}
@@ -105,13 +202,114 @@ func restartProgram() {
 	os.Exit(0)
 }
 
+//Prints the events from the ElevState event bus, as an example of a module that follows the elevators without
+//being wired into the channels
+func logEvents() {
+	events, _ := ElevState.Subscribe(100)
+	for event := range events {
+		log.Printf("%-14s %s floor=%d button=%d %s\n", event.Type, event.PeerID, event.Floor, event.Button, event.Detail)
+	}
+}
+
+//Reads commands from the terminal. "assigner" prints the assigner in use, "assigner <name>" switches all the
+//elevators to another one, "metrics" prints the assignment and network metrics and "explain <floor> <up|down>"
+//prints why the hall call went to the car it did. "maintenance <on|off>" takes the elevator out of hall request
+//assignment, and "keys" reads the key file again. "faults" prints the faults injected into the network, "faults off"
+//removes them and "faults <peer> <setting>=<value> ..." sets them for a peer (see Network/Faults.go)
+func console(FSMEventMsg chan<- ElevState.EventMessage) {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch {
+		case fields[0] == "assigner" && len(fields) == 1:
+			policy := ElevState.CurrentPolicy()
+			fmt.Println("Assigner", policy.Name, "set by", policy.SetBy+", available:", strings.Join(DistributeOrders.AssignerNames(), ", "))
+		case fields[0] == "assigner" && len(fields) == 2:
+			if _, ok := DistributeOrders.LookupAssigner(fields[1]); !ok {
+				fmt.Println("Unknown assigner", fields[1]+", must be one of:", strings.Join(DistributeOrders.AssignerNames(), ", "))
+				continue
+			}
+			ElevState.SetDispatchPolicy(fields[1])
+		case fields[0] == "metrics":
+			m := DistributeOrders.Metrics()
+			fmt.Printf("Assignments: %d received, %d coalesced, %d skipped, %d runs, %d errors, %d calls held\n", m.Received, m.Coalesced, m.Skipped, m.Runs, m.Errors, m.Held)
+			fmt.Printf("Divergence: %d calls took the designated elevator's choice, %d of them on the same states\n", m.Diverged, m.DivergedSame)
+			fmt.Println("Latency: last", m.LastLatency, "average", m.AverageLatency, "max", m.MaxLatency, "last run", m.LastRun)
+			b := bcast.Statistics()
+			fmt.Printf("Network: %d sent, %d received, %d fragments sent, %d received, %d incomplete, %d encode errors, %d decode errors, %d unknown\n", b.Sent, b.Received, b.SentFragments, b.ReceivedFragments, b.Incomplete, b.EncodeErrors, b.DecodeErrors, b.Unknown)
+			if b.LastError != "" {
+				fmt.Println("Last network error:", b.LastError)
+			}
+			r := Network.ReliableStatistics()
+			fmt.Printf("Critical messages: %d queued, %d retransmitted, %d acknowledged, %d gave up, received %d in order, %d duplicates, %d out of order, %d skipped\n", r.Queued, r.Retransmitted, r.Acknowledged, r.GaveUp, r.Delivered, r.Duplicates, r.OutOfOrder, r.Skipped)
+			f := conn.FaultStatistics()
+			fmt.Printf("Injected faults: %d dropped, %d duplicated, %d delayed, %d reordered\n", f.Dropped, f.Duplicated, f.Delayed, f.Reordered)
+			s := conn.SecurityStatistics()
+			fmt.Printf("Security: %d accepted, rejected %d unauthenticated, %d unknown key, %d bad tag, %d sealed without keys, %d stale, %d replayed\n", s.Accepted, s.Unauthenticated, s.UnknownKey, s.BadTag, s.NoKeys, s.Stale, s.Replayed)
+		case fields[0] == "keys":
+			if err := Network.LoadKeys(); err != nil {
+				fmt.Println("Error loading keys, keeping the ones in use:", err)
+			}
+		case fields[0] == "faults" && len(fields) == 1:
+			Network.PrintFaults()
+		case fields[0] == "faults" && len(fields) == 2 && fields[1] == "off":
+			conn.ClearFaults()
+		case fields[0] == "faults":
+			if err := Network.ApplyFaults(strings.Join(fields[1:], " ")); err != nil {
+				fmt.Println("Error setting faults:", err)
+			}
+		case fields[0] == "explain" && len(fields) == 3:
+			floor, err := strconv.Atoi(fields[1])
+			button, known := map[string]elevio.ButtonType{"up": elevio.BT_HallUp, "down": elevio.BT_HallDown}[fields[2]]
+			if err != nil || !known {
+				fmt.Println("Use: explain <floor> <up|down>")
+				continue
+			}
+			explanation, exists := DistributeOrders.Explain(floor, button)
+			if !exists {
+				fmt.Println("No hall call at floor", floor, fields[2])
+				continue
+			}
+			data, _ := json.MarshalIndent(explanation, "", "  ")
+			fmt.Println(string(data))
+		case fields[0] == "maintenance" && len(fields) == 2 && (fields[1] == "on" || fields[1] == "off"):
+			event := map[string]string{"on": "MaintenanceOn", "off": "MaintenanceOff"}[fields[1]]
+			FSMEventMsg <- ElevState.EventMessage{EventType: event} //ElevState handles it like the faults from the FSM
+		default:
+			fmt.Println("Unknown command, use: assigner [name], metrics, explain <floor> <up|down>, keys, faults [off|<peer> <setting>=<value> ...] or maintenance <on|off>")
+		}
+	}
+}
+
 //Assign the global variables to the modules
 func assignGlobalVars() {
 	ElevState.NFLOORS = NFLOORS
 	ElevState.ID = ID
+	ElevState.BackupPath = BACKUP
+	ElevState.DefaultPolicy = ASSIGNER
 
 	FSM.NFLOORS = NFLOORS
 	FSM.ID = ID
+	FSM.DoorTime = ElevState.CarDoorTime
 
 	DistributeOrders.ID = ID
 }
+
+//Returns the floors in the comma separated list as served, or nil if the list is empty and every floor is served
+func parseServedFloors(list string, floors int) ([]bool, error) {
+	if list == "" {
+		return nil, nil
+	}
+	served := make([]bool, floors)
+	for _, field := range strings.Split(list, ",") {
+		floor, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || floor < 0 || floor >= floors {
+			return nil, fmt.Errorf("served floor %q is not a floor between 0 and %d", field, floors-1)
+		}
+		served[floor] = true
+	}
+	return served, nil
+}